@@ -0,0 +1,316 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// ChatScope restricts the kind of chat a command may be used in
+type ChatScope uint8
+
+const (
+	ChatAny ChatScope = iota
+	ChatPrivate
+	ChatGroup
+)
+
+// Access restricts who may run a command and where. Every rule that is set
+// must pass. Rules are checked before the handler and its middlewares run, for
+// the command itself and for callbacks of its responses.
+type Access struct {
+	// Only the bot owners listed in Config.Owners
+	OwnerOnly bool
+
+	// Only administrators of the chat. On Discord this requires the
+	// Administrator permission, while on Telegram the user must be the chat's
+	// creator or an administrator. Always passes in private chats.
+	AdminOnly bool
+
+	// Discord permissions the user needs in the channel (i.e.
+	// discordgo.PermissionManageMessages)
+	DiscordPermissions int64
+
+	// Discord role IDs, of which the user needs at least one
+	DiscordRoles []string
+
+	// Platform user IDs that are always allowed, bypassing every other rule
+	// except DenyUsers and Chats
+	AllowUsers map[Platform][]string
+
+	// Platform user IDs that are never allowed
+	DenyUsers map[Platform][]string
+
+	// Kind of chat the command may be used in
+	Chats ChatScope
+}
+
+// accessDenied is the response to users who may not run a command. It is
+// only ephemeral on Discord, as Telegram would send it privately.
+func accessDenied(reason string, p Platform) *Message {
+	msg := &Message{Title: "🚫 You can't use this command", Description: reason}
+	if p == PlatformDiscord {
+		msg.Visibility = VisibilityEphemeral
+	}
+
+	return msg
+}
+
+// accessMiddleware refuses requests that do not pass the command's access
+// rules
+func (c *Config) accessMiddleware(a *Access) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) *Message {
+			// Callbacks are checked before running, as their denial must not
+			// replace the message they belong to
+			if req.Callback {
+				return next(req)
+			}
+
+			if reason := c.checkAccess(req, a); reason != "" {
+				return accessDenied(reason, req.Target.Platform)
+			}
+
+			return next(req)
+		}
+	}
+}
+
+// callbackAllowed checks whether the user may use the callback, based on its
+// owner, expiry and the access rules and rate limits of the command that
+// created it. Continuations are not charged to the rate limits.
+func (c *Config) callbackAllowed(cb Callback, req *Request) error {
+	if err := cb.allowed(req.UserID); err != nil {
+		return err
+	}
+
+	if cb.origin != nil {
+		req.Command = cb.origin.name()
+
+		if cb.origin.Access != nil {
+			if reason := c.checkAccess(req, cb.origin.Access); reason != "" {
+				return errors.New(reason)
+			}
+		}
+	}
+
+	if cb.continuation {
+		return nil
+	}
+
+	if wait := c.take(req, cb.origin); wait > 0 {
+		return errors.New(cooldown(wait))
+	}
+
+	return nil
+}
+
+// checkAccess returns why the request does not pass the rules, or an empty
+// string if it does. Rules that cannot be checked are failed.
+func (c *Config) checkAccess(req *Request, a *Access) string {
+	ctx := req.Context()
+	p := req.Target.Platform
+
+	if slices.Contains(a.DenyUsers[p], req.UserID) {
+		return "You are not allowed to use this command."
+	}
+
+	private, err := c.isPrivate(req)
+	if err != nil {
+		c.logger().Error("Failed to check the chat", "command", req.Command, "error", err)
+		return "Failed to check the chat. Please try again later."
+	}
+
+	switch {
+	case a.Chats == ChatPrivate && !private:
+		return "This command can only be used in private chats."
+	case a.Chats == ChatGroup && private:
+		return "This command can only be used in groups."
+	}
+
+	if slices.Contains(a.AllowUsers[p], req.UserID) {
+		return ""
+	}
+
+	if a.OwnerOnly && !slices.Contains(c.Owners[p], req.UserID) {
+		return "This command can only be used by the bot's owners."
+	}
+
+	if a.AdminOnly && !private {
+		admin, err := c.isAdmin(ctx, req)
+		if err != nil {
+			c.logger().Error("Failed to check permissions", "command", req.Command, "error", err)
+			return "Failed to check your permissions. Please try again later."
+		}
+
+		if !admin {
+			return "This command can only be used by administrators."
+		}
+	}
+
+	if p == PlatformDiscord && (a.DiscordPermissions != 0 || len(a.DiscordRoles) > 0) {
+		if private {
+			return "This command can only be used in servers."
+		}
+
+		member, perms, err := c.discordMember(req)
+		if err != nil {
+			c.logger().Error("Failed to check permissions", "command", req.Command, "error", err)
+			return "Failed to check your permissions. Please try again later."
+		}
+
+		if perms&a.DiscordPermissions != a.DiscordPermissions {
+			return "You are missing the permissions required for this command."
+		}
+
+		if len(a.DiscordRoles) > 0 && !slices.ContainsFunc(member.Roles, func(r string) bool {
+			return slices.Contains(a.DiscordRoles, r)
+		}) {
+			return "You are missing the roles required for this command."
+		}
+	}
+
+	return ""
+}
+
+// isPrivate reports whether the request was made in a private chat
+func (c *Config) isPrivate(req *Request) (bool, error) {
+	switch req.Target.Platform {
+	case PlatformDiscord:
+		if req.discordGuildID != nil {
+			return *req.discordGuildID == "", nil
+		}
+
+		ch, err := c.discordChannel(req.Target.ChatID)
+		if err != nil {
+			return false, err
+		}
+
+		return ch.Type == discordgo.ChannelTypeDM || ch.Type == discordgo.ChannelTypeGroupDM, nil
+
+	case PlatformTelegram:
+		// Private chats share the user's ID
+		return req.Target.ChatID == req.UserID, nil
+
+	default:
+		return false, fmt.Errorf("unsupported platform '%d'", req.Target.Platform)
+	}
+}
+
+// isAdmin reports whether the user administers the chat the request was made in
+func (c *Config) isAdmin(ctx context.Context, req *Request) (bool, error) {
+	switch req.Target.Platform {
+	case PlatformDiscord:
+		_, perms, err := c.discordMember(req)
+		if err != nil {
+			return false, err
+		}
+
+		return perms&discordgo.PermissionAdministrator != 0, nil
+
+	case PlatformTelegram:
+		if c.telegram.Load() == nil {
+			return false, ErrPlatformUnavailable
+		}
+
+		userID, err := strconv.ParseInt(req.UserID, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid Telegram user ID '%s': %w", req.UserID, err)
+		}
+
+		m, err := c.telegram.Load().GetChatMember(ctx, &bot.GetChatMemberParams{
+			ChatID: req.Target.telegramChat(),
+			UserID: userID,
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to get chat member: %w", err)
+		}
+
+		return m.Type == models.ChatMemberTypeOwner || m.Type == models.ChatMemberTypeAdministrator, nil
+
+	default:
+		return false, fmt.Errorf("unsupported platform '%d'", req.Target.Platform)
+	}
+}
+
+// discordMember returns the user's guild membership and permissions in the
+// channel, preferring those sent along with interactions
+func (c *Config) discordMember(req *Request) (*discordgo.Member, int64, error) {
+	if m := req.discordMember; m != nil {
+		return m, m.Permissions, nil
+	}
+
+	if c.discord.Load() == nil {
+		return nil, 0, ErrPlatformUnavailable
+	}
+
+	ch, err := c.discordChannel(req.Target.ChatID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	member, err := c.discord.Load().State.Member(ch.GuildID, req.UserID)
+	if err != nil {
+		if member, err = c.discord.Load().GuildMember(ch.GuildID, req.UserID); err != nil {
+			return nil, 0, fmt.Errorf("failed to get guild member: %w", err)
+		}
+	}
+
+	perms, err := c.discord.Load().UserChannelPermissions(req.UserID, ch.ID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get permissions: %w", err)
+	}
+
+	return member, perms, nil
+}
+
+// discordChannel returns the channel from the state, falling back to the API
+func (c *Config) discordChannel(id string) (*discordgo.Channel, error) {
+	if c.discord.Load() == nil {
+		return nil, ErrPlatformUnavailable
+	}
+
+	if ch, err := c.discord.Load().State.Channel(id); err == nil {
+		return ch, nil
+	}
+
+	ch, err := c.discord.Load().Channel(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	return ch, nil
+}
+
+// applyDiscordAccess hides the command from users Discord already knows
+// cannot pass its access rules, unless the command sets its own defaults
+func (a *Access) applyDiscordAccess(cmd *discordgo.ApplicationCommand) {
+	if a == nil {
+		return
+	}
+
+	perms := a.DiscordPermissions
+	if a.AdminOnly {
+		perms |= discordgo.PermissionAdministrator
+	}
+
+	// Allowed users bypass permissions, which Discord cannot express
+	if perms != 0 && len(a.AllowUsers[PlatformDiscord]) == 0 && cmd.DefaultMemberPermissions == nil {
+		cmd.DefaultMemberPermissions = &perms
+	}
+
+	if cmd.Contexts == nil {
+		switch a.Chats {
+		case ChatPrivate:
+			cmd.Contexts = &[]discordgo.InteractionContextType{discordgo.InteractionContextBotDM}
+		case ChatGroup:
+			cmd.Contexts = &[]discordgo.InteractionContextType{discordgo.InteractionContextGuild}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckAccess(t *testing.T) {
+	private := Target{Platform: PlatformTelegram, ChatID: "1"}
+	group := Target{Platform: PlatformTelegram, ChatID: "-100"}
+	owners := map[Platform][]string{PlatformTelegram: {"1"}}
+
+	tests := []struct {
+		name    string
+		access  Access
+		target  Target
+		userID  string
+		allowed bool
+	}{
+		{"no rules", Access{}, group, "2", true},
+		{"denied user", Access{DenyUsers: map[Platform][]string{PlatformTelegram: {"2"}}}, group, "2", false},
+		{"denied elsewhere", Access{DenyUsers: map[Platform][]string{PlatformDiscord: {"2"}}}, group, "2", true},
+		{"private only in private", Access{Chats: ChatPrivate}, private, "1", true},
+		{"private only in group", Access{Chats: ChatPrivate}, group, "1", false},
+		{"group only in group", Access{Chats: ChatGroup}, group, "1", true},
+		{"group only in private", Access{Chats: ChatGroup}, private, "1", false},
+		{"owner", Access{OwnerOnly: true}, group, "1", true},
+		{"not owner", Access{OwnerOnly: true}, group, "2", false},
+		{"allowed user skips owner", Access{OwnerOnly: true, AllowUsers: map[Platform][]string{PlatformTelegram: {"2"}}}, group, "2", true},
+		{"allowed user in wrong chat", Access{Chats: ChatPrivate, AllowUsers: map[Platform][]string{PlatformTelegram: {"2"}}}, group, "2", false},
+		{"denied beats allowed", Access{AllowUsers: map[Platform][]string{PlatformTelegram: {"2"}}, DenyUsers: map[Platform][]string{PlatformTelegram: {"2"}}}, group, "2", false},
+		{"admin in private", Access{AdminOnly: true}, Target{Platform: PlatformTelegram, ChatID: "2"}, "2", true},
+		{"unsupported platform", Access{}, Target{Platform: PlatformGuilded, ChatID: "chat"}, "2", false},
+	}
+
+	for _, tt := range tests {
+		c := &Config{Owners: owners}
+		req := newRequest(context.Background(), tt.target, "user", tt.userID)
+
+		reason := c.checkAccess(req, &tt.access)
+		if (reason == "") != tt.allowed {
+			t.Errorf("%s: checkAccess = %q, want allowed %t", tt.name, reason, tt.allowed)
+		}
+	}
+}
+
+func TestAccessMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		callback bool
+		ran      bool
+	}{
+		{"telegram", PlatformTelegram, false, false},
+		{"discord", PlatformDiscord, false, false},
+		{"callback", PlatformTelegram, true, true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		ran := false
+		h := c.accessMiddleware(&Access{OwnerOnly: true})(func(*Request) *Message {
+			ran = true
+			return &Message{}
+		})
+
+		guild := "guild"
+		req := newRequest(context.Background(), Target{Platform: tt.platform, ChatID: "chat"}, "user", "1")
+		req.discordGuildID = &guild
+		req.Callback = tt.callback
+
+		msg := h(req)
+		if ran != tt.ran {
+			t.Errorf("%s: handler ran = %t, want %t", tt.name, ran, tt.ran)
+		}
+
+		// Denials only go to the user where the platform can keep them private
+		if !ran && (msg.Visibility == VisibilityEphemeral) != (tt.platform == PlatformDiscord) {
+			t.Errorf("%s: denial visibility %d", tt.name, msg.Visibility)
+		}
+	}
+}
+
+func TestCallbackAllowed(t *testing.T) {
+	restricted := &Command{Text: TextCommand{Aliases: []string{"admin"}}, Access: &Access{OwnerOnly: true}}
+	limited := &Command{Text: TextCommand{Aliases: []string{"limited"}}, RateLimits: []RateLimit{{Burst: 1, Every: time.Hour}}}
+
+	tests := []struct {
+		name    string
+		cb      Callback
+		userID  string
+		wantErr error
+		allowed bool
+	}{
+		{"anyone", Callback{}, "2", nil, true},
+		{"owner", Callback{OwnerID: "1"}, "1", nil, true},
+		{"not owner", Callback{OwnerID: "1"}, "2", ErrCallbackNotOwner, false},
+		{"expired", Callback{Expires: time.Now().Add(-time.Minute)}, "1", ErrCallbackExpired, false},
+		{"not expired", Callback{Expires: time.Now().Add(time.Minute)}, "1", nil, true},
+		{"command access", Callback{origin: restricted}, "2", nil, false},
+		{"command owner", Callback{origin: restricted}, "1", nil, true},
+		{"rate limited", Callback{origin: limited}, "1", nil, false},
+		{"continuation", Callback{origin: limited, continuation: true}, "1", nil, true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{Owners: map[Platform][]string{PlatformTelegram: {"1"}}}
+		target := Target{Platform: PlatformTelegram, ChatID: "-100"}
+
+		// Use up the command's rate limits first
+		if tt.cb.origin == limited {
+			c.take(newRequest(context.Background(), target, "user", tt.userID), limited)
+		}
+
+		err := c.callbackAllowed(tt.cb, newRequest(context.Background(), target, "user", tt.userID))
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: callbackAllowed = %v, want allowed %t", tt.name, err, tt.allowed)
+		}
+
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: callbackAllowed = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
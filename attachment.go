@@ -0,0 +1,382 @@
+package crossbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Platform upload limits
+const (
+	discordMaxUploadSize  = 10 << 20
+	telegramMaxPhotoSize  = 10 << 20
+	telegramMaxUploadSize = 50 << 20
+	telegramCaptionLimit  = 1024
+	telegramMediaGroupMax = 10
+)
+
+// attachmentClient downloads attachments given by URL
+var attachmentClient = &http.Client{Timeout: 30 * time.Second}
+
+// Attachment is a file sent along with a message. The content is taken from
+// Data, Reader or URL, in that order. A Reader is read once the message is
+// sent, so messages sent several times must use Data instead.
+type Attachment struct {
+	// File name shown to users, including the extension
+	Name string
+
+	// MIME type of the content. Guessed from the name if unspecified.
+	ContentType string
+
+	Data   []byte
+	Reader io.Reader
+	URL    string
+}
+
+// mimeType returns the attachment's MIME type, guessing it from the file
+// name or URL if unspecified
+func (a *Attachment) mimeType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+
+	name := a.Name
+	if name == "" {
+		if u, err := url.Parse(a.URL); err == nil {
+			name = u.Path
+		}
+	}
+
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// fileName returns the attachment's name, falling back to the URL's base name
+func (a *Attachment) fileName() string {
+	if a.Name != "" {
+		return a.Name
+	}
+
+	if u, err := url.Parse(a.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		return path.Base(u.Path)
+	}
+
+	return "file"
+}
+
+// isPhoto reports whether Telegram can display the attachment as a photo
+func (a *Attachment) isPhoto() bool {
+	switch a.mimeType() {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// load returns the attachment's content, reading the Reader or downloading it
+// if only a URL is set. An error is returned if the content is larger than
+// limit bytes.
+func (a *Attachment) load(ctx context.Context, limit int64) ([]byte, error) {
+	data := a.Data
+	if data == nil && a.Reader != nil {
+		var err error
+		if data, err = io.ReadAll(a.Reader); err != nil {
+			return nil, fmt.Errorf("failed to read attachment '%s': %w", a.fileName(), err)
+		}
+	}
+
+	if data == nil && a.URL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := attachmentClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download attachment '%s': %w", a.URL, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to download attachment '%s': %s", a.URL, resp.Status)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to download attachment '%s': %w", a.URL, err)
+		}
+
+		if int64(len(data)) > limit {
+			return nil, fmt.Errorf("attachment '%s' exceeds the %d byte limit", a.fileName(), limit)
+		}
+
+		return data, nil
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("attachment '%s' exceeds the %d byte limit", a.fileName(), limit)
+	}
+
+	return data, nil
+}
+
+// loadAttachments returns a copy of the message with its attachments loaded
+// for upload, so that downloads happen before the message is queued instead
+// of holding up the chat's queue, and the caller's message is never changed.
+// Attachments that cannot be loaded are logged and left out. Telegram fetches
+// URLs itself, so those are left to it.
+func (c *Config) loadAttachments(ctx context.Context, msg *Message, p Platform) *Message {
+	if msg == nil || len(msg.Attachments) == 0 {
+		return msg
+	}
+
+	res := *msg
+	res.Attachments = make([]Attachment, 0, len(msg.Attachments))
+	for i := range msg.Attachments {
+		a := &msg.Attachments[i]
+
+		limit := int64(discordMaxUploadSize)
+		if p == PlatformTelegram {
+			if a.Data == nil && a.Reader == nil {
+				res.Attachments = append(res.Attachments, *a)
+				continue
+			}
+
+			limit = a.telegramLimit()
+		}
+
+		data, err := a.load(ctx, limit)
+		if err != nil {
+			c.logger().Warn("Skipping attachment", "platform", platformName(p), "error", err)
+			continue
+		}
+
+		loaded := *a
+		loaded.Data, loaded.Reader = data, nil
+		res.Attachments = append(res.Attachments, loaded)
+	}
+
+	return &res
+}
+
+// discordFiles returns the message's attachments for upload to Discord.
+// Messages sent through a config have their attachments loaded beforehand,
+// while attachments of other messages that cannot be loaded are skipped.
+func (m *Message) discordFiles() (files []*discordgo.File) {
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		data, err := a.load(context.Background(), discordMaxUploadSize)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, &discordgo.File{
+			Name:        a.fileName(),
+			ContentType: a.mimeType(),
+			Reader:      bytes.NewReader(data),
+		})
+	}
+
+	return files
+}
+
+// telegramLimit returns the largest size Telegram accepts for the attachment
+func (a *Attachment) telegramLimit() int64 {
+	if a.isPhoto() {
+		return telegramMaxPhotoSize
+	}
+
+	return telegramMaxUploadSize
+}
+
+// telegramFile returns the attachment as a Telegram upload, or as a URL for
+// Telegram to fetch itself
+func (a *Attachment) telegramFile() (models.InputFile, io.Reader, error) {
+	if a.Data == nil && a.Reader == nil {
+		if a.URL == "" {
+			return nil, nil, fmt.Errorf("attachment '%s' has no content", a.fileName())
+		}
+
+		return &models.InputFileString{Data: a.URL}, nil, nil
+	}
+
+	data, err := a.load(context.Background(), a.telegramLimit())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bytes.NewReader(data)
+	return &models.InputFileUpload{Filename: a.fileName(), Data: r}, r, nil
+}
+
+// telegramReply holds where a Telegram message is sent
+type telegramReply struct {
+	ChatID          any
+	MessageThreadID int
+	ReplyParameters *models.ReplyParameters
+}
+
+// sendTelegram sends a message along with its attachments. Photos and
+// documents are sent with the message text as their caption when it fits,
+// otherwise the text is sent as a separate message first. The first sent
+// message is returned.
+func sendTelegram(ctx context.Context, b *bot.Bot, to telegramReply, msg *Message) (*models.Message, error) {
+	text, markup := msg.Telegram()
+
+	// Embed images are sent as photos, captioned with the text when possible
+	var files []*Attachment
+	images := msg.images()
+	for i := range images {
+		files = append(files, &images[i])
+	}
+
+	for i := range msg.Attachments {
+		files = append(files, &msg.Attachments[i])
+	}
+
+	// Media groups cannot carry buttons, so the text is sent separately
+	caption := text
+	if len(files) == 0 || telegramLimits.length(text) > telegramCaptionLimit || (len(files) > 1 && markup != nil) {
+		caption = ""
+	}
+
+	var first *models.Message
+	if len(files) == 0 || (caption == "" && strings.TrimSpace(text) != "") {
+		m, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:          to.ChatID,
+			MessageThreadID: to.MessageThreadID,
+			Text:            text,
+			ParseMode:       models.ParseModeMarkdown,
+			ReplyParameters: to.ReplyParameters,
+			ReplyMarkup:     markup,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		first, markup = m, nil
+	}
+
+	if len(files) == 1 {
+		m, err := sendTelegramFile(ctx, b, to, files[0], caption, markup)
+		if err != nil {
+			return first, err
+		}
+
+		if first == nil {
+			first = m
+		}
+
+		return first, nil
+	}
+
+	for len(files) > 0 {
+		// Media groups need at least two items, so none may be left alone
+		n := min(len(files), telegramMediaGroupMax)
+		if len(files)-n == 1 {
+			n--
+		}
+		ms, err := sendTelegramMediaGroup(ctx, b, to, files[:n], caption)
+		if err != nil {
+			return first, err
+		}
+
+		if first == nil && len(ms) > 0 {
+			first = ms[0]
+		}
+
+		files, caption = files[n:], ""
+	}
+
+	return first, nil
+}
+
+func sendTelegramFile(ctx context.Context, b *bot.Bot, to telegramReply, a *Attachment, caption string, markup models.ReplyMarkup) (*models.Message, error) {
+	file, _, err := a.telegramFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if a.isPhoto() {
+		return b.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:          to.ChatID,
+			MessageThreadID: to.MessageThreadID,
+			Photo:           file,
+			Caption:         caption,
+			ParseMode:       models.ParseModeMarkdown,
+			ReplyParameters: to.ReplyParameters,
+			ReplyMarkup:     markup,
+		})
+	}
+
+	return b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:          to.ChatID,
+		MessageThreadID: to.MessageThreadID,
+		Document:        file,
+		Caption:         caption,
+		ParseMode:       models.ParseModeMarkdown,
+		ReplyParameters: to.ReplyParameters,
+		ReplyMarkup:     markup,
+	})
+}
+
+// sendTelegramMediaGroup sends up to ten attachments as an album. Telegram
+// does not allow documents to be mixed with photos, so unless every
+// attachment is a photo they are all sent as documents.
+func sendTelegramMediaGroup(ctx context.Context, b *bot.Bot, to telegramReply, files []*Attachment, caption string) ([]*models.Message, error) {
+	photos := true
+	for _, a := range files {
+		photos = photos && a.isPhoto()
+	}
+
+	var media []models.InputMedia
+	names := make(map[string]bool, len(files))
+	for i, a := range files {
+		file, r, err := a.telegramFile()
+		if err != nil {
+			return nil, err
+		}
+
+		// Uploads are referred to by their file name, which must be unique
+		// within the album
+		name := a.fileName()
+		if names[name] {
+			name = fmt.Sprintf("%d-%s", i, name)
+		}
+		names[name] = true
+
+		ref := "attach://" + name
+		if s, ok := file.(*models.InputFileString); ok {
+			ref = s.Data
+		}
+
+		var itemCaption string
+		if i == 0 {
+			itemCaption = caption
+		}
+
+		if photos {
+			media = append(media, &models.InputMediaPhoto{Media: ref, Caption: itemCaption, ParseMode: models.ParseModeMarkdown, MediaAttachment: r})
+		} else {
+			media = append(media, &models.InputMediaDocument{Media: ref, Caption: itemCaption, ParseMode: models.ParseModeMarkdown, MediaAttachment: r})
+		}
+	}
+
+	return b.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
+		ChatID:          to.ChatID,
+		MessageThreadID: to.MessageThreadID,
+		Media:           media,
+		ReplyParameters: to.ReplyParameters,
+	})
+}
@@ -0,0 +1,422 @@
+package crossbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// bridgeHistory is the number of relayed messages remembered per config, used
+// to mirror replies, edits and deletions
+const bridgeHistory = 1000
+
+// Bridge mirrors messages between a Discord channel and a Telegram chat. The
+// Discord session requires the MessageContent intent to read message text.
+type Bridge struct {
+	DiscordChannelID string
+	TelegramChatID   string
+
+	// Optional Telegram topic to mirror instead of the whole chat
+	TelegramThreadID string
+
+	// Relay messages from other bots. Disabled by default, which also prevents
+	// loops between multiple bridge bots.
+	IncludeBots bool
+
+	// Function deciding whether a message is relayed. All messages are relayed
+	// if unspecified.
+	Filter func(m *BridgeMessage) bool
+}
+
+// BridgeMessage is a chat message about to be relayed across a bridge
+type BridgeMessage struct {
+	// Platform the message was sent on
+	Platform Platform
+
+	Author string
+	Text   string
+
+	// Links or descriptions of attached files that cannot be re-uploaded
+	Attachments []string
+
+	// Attached files re-uploaded to the other side. Files that fail to upload
+	// are linked to or described instead.
+	Files []Attachment
+}
+
+type bridgeLinks struct {
+	mu    sync.Mutex
+	refs  map[string]MessageRef
+	order []string
+}
+
+// bridgeFor returns the bridge a target belongs to
+func (c *Config) bridgeFor(t Target) (*Bridge, bool) {
+	for i, b := range c.Bridges {
+		switch t.Platform {
+		case PlatformDiscord:
+			if b.DiscordChannelID == t.ChatID {
+				return &c.Bridges[i], true
+			}
+		case PlatformTelegram:
+			if b.TelegramChatID == t.ChatID && (b.TelegramThreadID == "" || b.TelegramThreadID == t.ThreadID) {
+				return &c.Bridges[i], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// other returns the opposite end of the bridge
+func (b *Bridge) other(p Platform) Target {
+	if p == PlatformDiscord {
+		return Target{Platform: PlatformTelegram, ChatID: b.TelegramChatID, ThreadID: b.TelegramThreadID}
+	}
+
+	return Target{Platform: PlatformDiscord, ChatID: b.DiscordChannelID}
+}
+
+// relay mirrors a new message to the other side of its bridge
+func (c *Config) relay(src MessageRef, m *BridgeMessage, replyTo string) {
+	defer c.recoverPanic(bridgeName(src.Target.Platform))
+
+	b, ok := c.bridgeFor(src.Target)
+	if !ok || (b.Filter != nil && !b.Filter(m)) {
+		return
+	}
+
+	var reply string
+	if replyTo != "" {
+		if ref, ok := c.bridgeLink(MessageRef{Target: src.Target, MessageID: replyTo}); ok {
+			reply = ref.MessageID
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	to := b.other(src.Target.Platform)
+	ref, err := c.send(ctx, to, m.message(), reply)
+	if err != nil && len(m.Files) > 0 {
+		c.logger().Warn("Failed to upload bridged files", "error", err)
+		ref, err = c.send(ctx, to, m.withoutFiles().message(), reply)
+	}
+	if err != nil {
+		c.logger().Error("Failed to relay bridged message", "error", err)
+		return
+	}
+
+	c.linkBridged(src, *ref)
+}
+
+// relayEdit mirrors an edited message to the other side of its bridge
+func (c *Config) relayEdit(src MessageRef, m *BridgeMessage) {
+	defer c.recoverPanic(bridgeName(src.Target.Platform))
+
+	b, ok := c.bridgeFor(src.Target)
+	if !ok || (b.Filter != nil && !b.Filter(m)) {
+		return
+	}
+
+	ref, ok := c.bridgeLink(src)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.Edit(ctx, &ref, m.message()); err != nil {
+		c.logger().Error("Failed to relay bridged edit", "error", err)
+	}
+}
+
+// relayDelete mirrors a deleted message to the other side of its bridge
+func (c *Config) relayDelete(src MessageRef) {
+	ref, ok := c.bridgeLink(src)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.Delete(ctx, &ref); err != nil {
+		c.logger().Error("Failed to relay bridged deletion", "error", err)
+	}
+}
+
+// message renders the relayed message with the author as its title. The text
+// is escaped, so that markup of one platform cannot inject links or
+// formatting into the other.
+func (m *BridgeMessage) message() *Message {
+	text := m.Text
+	if len(m.Attachments) > 0 {
+		text = strings.TrimSpace(text + "\n" + strings.Join(m.Attachments, "\n"))
+	}
+
+	msg := &Message{Title: m.Author, Attachments: m.Files}
+	if text != "" {
+		msg.RichDescription = RichText{Plain(text)}
+	}
+
+	return msg
+}
+
+// withoutFiles returns a copy of the message with its files linked to, or
+// named if they have no URL
+func (m *BridgeMessage) withoutFiles() *BridgeMessage {
+	res := *m
+	res.Files = nil
+	res.Attachments = append([]string(nil), m.Attachments...)
+	for _, a := range m.Files {
+		if a.URL != "" {
+			res.Attachments = append(res.Attachments, a.URL)
+		} else {
+			res.Attachments = append(res.Attachments, fmt.Sprintf("[file: %s]", a.fileName()))
+		}
+	}
+
+	return &res
+}
+
+// bridgeName names the bridge relaying messages from the platform in logs
+func bridgeName(p Platform) string {
+	switch p {
+	case PlatformDiscord:
+		return "Discord bridge"
+	case PlatformTelegram:
+		return "Telegram bridge"
+	default:
+		return "bridge"
+	}
+}
+
+// linkBridged remembers a relayed message in both directions
+func (c *Config) linkBridged(a, b MessageRef) {
+	c.bridges.mu.Lock()
+	defer c.bridges.mu.Unlock()
+
+	if c.bridges.refs == nil {
+		c.bridges.refs = make(map[string]MessageRef)
+	}
+
+	for _, pair := range [][2]MessageRef{{a, b}, {b, a}} {
+		key := pair[0].key()
+		c.bridges.refs[key] = pair[1]
+		c.bridges.order = append(c.bridges.order, key)
+	}
+
+	// Forget the oldest messages
+	for len(c.bridges.order) > bridgeHistory*2 {
+		delete(c.bridges.refs, c.bridges.order[0])
+		c.bridges.order = c.bridges.order[1:]
+	}
+}
+
+// bridgeLink returns the mirrored counterpart of a message
+func (c *Config) bridgeLink(ref MessageRef) (MessageRef, bool) {
+	c.bridges.mu.Lock()
+	defer c.bridges.mu.Unlock()
+
+	r, ok := c.bridges.refs[ref.key()]
+	return r, ok
+}
+
+func (r MessageRef) key() string {
+	return fmt.Sprintf("%d:%s:%s", r.Target.Platform, r.Target.ChatID, r.MessageID)
+}
+
+// registerDiscordBridges relays messages from bridged Discord channels
+func (c *Config) registerDiscordBridges(dg *discordgo.Session) {
+	if len(c.Bridges) == 0 {
+		return
+	}
+
+	accept := func(s *discordgo.Session, m *discordgo.Message) (*BridgeMessage, bool) {
+		if m.Author == nil || m.Author.ID == s.State.User.ID {
+			return nil, false
+		}
+
+		b, ok := c.bridgeFor(Target{Platform: PlatformDiscord, ChatID: m.ChannelID})
+		if !ok || (m.Author.Bot && !b.IncludeBots) {
+			return nil, false
+		}
+
+		return discordBridgeMessage(m), true
+	}
+
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		defer c.recoverPanic("Discord bridge")
+
+		bm, ok := accept(s, m.Message)
+		if !ok {
+			return
+		}
+
+		var replyTo string
+		if m.MessageReference != nil {
+			replyTo = m.MessageReference.MessageID
+		}
+
+		c.relay(discordRef(m.Message), bm, replyTo)
+	})
+
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+		defer c.recoverPanic("Discord bridge")
+
+		bm, ok := accept(s, m.Message)
+		if !ok {
+			return
+		}
+
+		c.relayEdit(discordRef(m.Message), bm)
+	})
+
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageDelete) {
+		defer c.recoverPanic("Discord bridge")
+
+		c.relayDelete(discordRef(m.Message))
+	})
+}
+
+func discordRef(m *discordgo.Message) MessageRef {
+	return MessageRef{Target: Target{Platform: PlatformDiscord, ChatID: m.ChannelID}, MessageID: m.ID}
+}
+
+func discordBridgeMessage(m *discordgo.Message) *BridgeMessage {
+	author := m.Author.GlobalName
+	if m.Member != nil && m.Member.Nick != "" {
+		author = m.Member.Nick
+	}
+	if author == "" {
+		author = m.Author.Username
+	}
+
+	// Attachments are public, so Telegram can fetch them itself
+	bm := &BridgeMessage{Platform: PlatformDiscord, Author: author, Text: m.Content}
+	for _, a := range m.Attachments {
+		bm.Files = append(bm.Files, Attachment{Name: a.Filename, ContentType: a.ContentType, URL: a.URL})
+	}
+
+	return bm
+}
+
+// telegramBridgeMiddleware relays messages from bridged Telegram chats. Bots
+// are never sent their own messages and Telegram does not report deletions,
+// so only new and edited messages are mirrored.
+func (c *Config) telegramBridgeMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		defer next(ctx, b, update)
+
+		m, edited := update.Message, false
+		if m == nil {
+			m, edited = update.EditedMessage, true
+		}
+		if m == nil {
+			return
+		}
+
+		target := telegramTarget(m)
+		br, ok := c.bridgeFor(target)
+		if !ok || (m.From != nil && m.From.IsBot && !br.IncludeBots) {
+			return
+		}
+
+		ref := MessageRef{Target: target, MessageID: fmt.Sprint(m.ID)}
+		bm := telegramBridgeMessage(m)
+		if edited {
+			go c.relayEdit(ref, bm)
+			return
+		}
+
+		var replyTo string
+		if m.ReplyToMessage != nil {
+			replyTo = fmt.Sprint(m.ReplyToMessage.ID)
+		}
+
+		files := telegramFiles(b, m)
+		go func() {
+			defer c.recoverPanic(bridgeName(PlatformTelegram))
+
+			c.downloadBridged(bm, files)
+			c.relay(ref, bm, replyTo)
+		}()
+	}
+}
+
+// downloadBridged downloads the files of a Telegram message for upload to
+// Discord, as their links contain the bot token and cannot be shared. Files
+// that are too large or fail to download stay described.
+func (c *Config) downloadBridged(bm *BridgeMessage, files []*File) {
+	if len(files) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var failed bool
+	for _, f := range files {
+		if f.Size > discordMaxUploadSize {
+			failed = true
+			continue
+		}
+
+		data, err := f.Bytes(ctx)
+		if err != nil {
+			c.logger().Warn("Failed to download bridged file", "error", err)
+			failed = true
+			continue
+		}
+
+		bm.Files = append(bm.Files, Attachment{Name: f.Name, ContentType: f.ContentType, Data: data})
+	}
+
+	if !failed {
+		bm.Attachments = nil
+	}
+}
+
+func telegramBridgeMessage(m *models.Message) *BridgeMessage {
+	var author string
+	switch {
+	case m.From != nil:
+		author = strings.TrimSpace(m.From.FirstName + " " + m.From.LastName)
+	case m.SenderChat != nil:
+		author = m.SenderChat.Title
+	}
+
+	text := m.Text
+	if text == "" {
+		text = m.Caption
+	}
+
+	// Attachments are described, and replaced by the files once downloaded
+	bm := &BridgeMessage{Platform: PlatformTelegram, Author: author, Text: text}
+	switch {
+	case len(m.Photo) > 0:
+		bm.Attachments = append(bm.Attachments, "[photo]")
+	case m.Video != nil:
+		bm.Attachments = append(bm.Attachments, "[video]")
+	case m.Animation != nil:
+		bm.Attachments = append(bm.Attachments, "[animation]")
+	case m.Voice != nil:
+		bm.Attachments = append(bm.Attachments, "[voice message]")
+	case m.Audio != nil:
+		bm.Attachments = append(bm.Attachments, fmt.Sprintf("[audio: %s]", m.Audio.FileName))
+	case m.Document != nil:
+		bm.Attachments = append(bm.Attachments, fmt.Sprintf("[file: %s]", m.Document.FileName))
+	case m.Sticker != nil:
+		bm.Attachments = append(bm.Attachments, fmt.Sprintf("[sticker %s]", m.Sticker.Emoji))
+	}
+
+	return bm
+}
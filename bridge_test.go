@@ -0,0 +1,239 @@
+package crossbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestBridgeFor(t *testing.T) {
+	c := &Config{Bridges: []Bridge{
+		{DiscordChannelID: "d1", TelegramChatID: "t1"},
+		{DiscordChannelID: "d2", TelegramChatID: "t2", TelegramThreadID: "5"},
+	}}
+
+	tests := []struct {
+		name   string
+		target Target
+		want   string
+		other  Target
+	}{
+		{"discord", Target{Platform: PlatformDiscord, ChatID: "d1"}, "d1", Target{Platform: PlatformTelegram, ChatID: "t1"}},
+		{"telegram", Target{Platform: PlatformTelegram, ChatID: "t1"}, "d1", Target{Platform: PlatformDiscord, ChatID: "d1"}},
+		{"telegram topic of whole chat", Target{Platform: PlatformTelegram, ChatID: "t1", ThreadID: "9"}, "d1", Target{Platform: PlatformDiscord, ChatID: "d1"}},
+		{"telegram topic", Target{Platform: PlatformTelegram, ChatID: "t2", ThreadID: "5"}, "d2", Target{Platform: PlatformDiscord, ChatID: "d2"}},
+		{"discord to topic", Target{Platform: PlatformDiscord, ChatID: "d2"}, "d2", Target{Platform: PlatformTelegram, ChatID: "t2", ThreadID: "5"}},
+		{"other telegram topic", Target{Platform: PlatformTelegram, ChatID: "t2", ThreadID: "6"}, "", Target{}},
+		{"unbridged", Target{Platform: PlatformDiscord, ChatID: "d3"}, "", Target{}},
+		{"same ID on other platform", Target{Platform: PlatformTelegram, ChatID: "d1"}, "", Target{}},
+	}
+
+	for _, tt := range tests {
+		b, ok := c.bridgeFor(tt.target)
+		if !ok {
+			if tt.want != "" {
+				t.Errorf("%s: no bridge found", tt.name)
+			}
+
+			continue
+		}
+
+		if b.DiscordChannelID != tt.want {
+			t.Errorf("%s: found bridge of %q, want %q", tt.name, b.DiscordChannelID, tt.want)
+		}
+
+		if other := b.other(tt.target.Platform); other != tt.other {
+			t.Errorf("%s: relays to %v, want %v", tt.name, other, tt.other)
+		}
+	}
+}
+
+func TestBridgeMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      BridgeMessage
+		markdown string
+		files    int
+	}{
+		{"text", BridgeMessage{Author: "Ann", Text: "hello"}, "hello", 0},
+		{"escaped", BridgeMessage{Author: "Ann", Text: "*bold* [link](https://example.com)"}, `\*bold\* \[link\]\(https://example\.com\)`, 0},
+		{"described", BridgeMessage{Author: "Ann", Text: "look", Attachments: []string{"[photo]"}}, `look
+\[photo\]`, 0},
+		{"only described", BridgeMessage{Author: "Ann", Attachments: []string{"[photo]"}}, `\[photo\]`, 0},
+		{"files", BridgeMessage{Author: "Ann", Files: []Attachment{{Name: "a.png", Data: []byte("a")}}}, "", 1},
+	}
+
+	for _, tt := range tests {
+		msg := tt.msg.message()
+		if msg.Title != tt.msg.Author {
+			t.Errorf("%s: titled %q, want the author", tt.name, msg.Title)
+		}
+
+		if got := msg.RichDescription.TelegramMarkdown(); got != tt.markdown {
+			t.Errorf("%s: rendered %q, want %q", tt.name, got, tt.markdown)
+		}
+
+		if len(msg.Attachments) != tt.files {
+			t.Errorf("%s: %d attachments, want %d", tt.name, len(msg.Attachments), tt.files)
+		}
+	}
+}
+
+func TestBridgeWithoutFiles(t *testing.T) {
+	bm := &BridgeMessage{
+		Author:      "Ann",
+		Attachments: []string{"[sticker 🙂]"},
+		Files:       []Attachment{{Name: "a.png", URL: "https://example.com/a.png"}, {Name: "b.txt", Data: []byte("b")}, {Data: []byte("c")}},
+	}
+
+	got := bm.withoutFiles()
+	want := []string{"[sticker 🙂]", "https://example.com/a.png", "[file: b.txt]", "[file: file]"}
+	if len(got.Files) != 0 || strings.Join(got.Attachments, "|") != strings.Join(want, "|") {
+		t.Errorf("withoutFiles has files %v and attachments %q, want %q", got.Files, got.Attachments, want)
+	}
+
+	if len(bm.Files) != 3 || len(bm.Attachments) != 1 {
+		t.Error("withoutFiles modified the original message")
+	}
+}
+
+func TestBridgeLinks(t *testing.T) {
+	c := &Config{}
+	ref := func(p Platform, id int) MessageRef {
+		return MessageRef{Target: Target{Platform: p, ChatID: "chat"}, MessageID: fmt.Sprint(id)}
+	}
+
+	for i := range bridgeHistory + 10 {
+		c.linkBridged(ref(PlatformDiscord, i), ref(PlatformTelegram, i))
+	}
+
+	tests := []struct {
+		name string
+		from MessageRef
+		want MessageRef
+		ok   bool
+	}{
+		{"discord to telegram", ref(PlatformDiscord, bridgeHistory), ref(PlatformTelegram, bridgeHistory), true},
+		{"telegram to discord", ref(PlatformTelegram, bridgeHistory+9), ref(PlatformDiscord, bridgeHistory+9), true},
+		{"oldest remembered", ref(PlatformDiscord, 10), ref(PlatformTelegram, 10), true},
+		{"forgotten", ref(PlatformDiscord, 9), MessageRef{}, false},
+		{"forgotten back", ref(PlatformTelegram, 0), MessageRef{}, false},
+		{"unknown", ref(PlatformDiscord, -1), MessageRef{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := c.bridgeLink(tt.from)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s: bridgeLink = %v, %t, want %v, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTelegramBridgeMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         models.Message
+		author      string
+		text        string
+		attachments []string
+	}{
+		{"text", models.Message{From: &models.User{FirstName: "Ann", LastName: "Lee"}, Text: "hi"}, "Ann Lee", "hi", nil},
+		{"first name", models.Message{From: &models.User{FirstName: "Ann"}, Text: "hi"}, "Ann", "hi", nil},
+		{"channel", models.Message{SenderChat: &models.Chat{Title: "News"}, Text: "hi"}, "News", "hi", nil},
+		{"photo", models.Message{From: &models.User{FirstName: "Ann"}, Caption: "look", Photo: []models.PhotoSize{{FileID: "p"}}}, "Ann", "look", []string{"[photo]"}},
+		{"document", models.Message{From: &models.User{FirstName: "Ann"}, Document: &models.Document{FileName: "a.pdf"}}, "Ann", "", []string{"[file: a.pdf]"}},
+	}
+
+	for _, tt := range tests {
+		bm := telegramBridgeMessage(&tt.msg)
+		if bm.Platform != PlatformTelegram || bm.Author != tt.author || bm.Text != tt.text {
+			t.Errorf("%s: relayed as %d by %q saying %q", tt.name, bm.Platform, bm.Author, bm.Text)
+		}
+
+		if strings.Join(bm.Attachments, "|") != strings.Join(tt.attachments, "|") {
+			t.Errorf("%s: attachments %q, want %q", tt.name, bm.Attachments, tt.attachments)
+		}
+	}
+}
+
+func TestDiscordBridgeMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    discordgo.Message
+		author string
+	}{
+		{"username", discordgo.Message{Author: &discordgo.User{Username: "ann"}}, "ann"},
+		{"global name", discordgo.Message{Author: &discordgo.User{Username: "ann", GlobalName: "Ann"}}, "Ann"},
+		{"nickname", discordgo.Message{Author: &discordgo.User{Username: "ann", GlobalName: "Ann"}, Member: &discordgo.Member{Nick: "Annie"}}, "Annie"},
+	}
+
+	for _, tt := range tests {
+		tt.msg.Content = "hi"
+		tt.msg.Attachments = []*discordgo.MessageAttachment{{Filename: "a.png", URL: "https://cdn.example.com/a.png"}}
+
+		bm := discordBridgeMessage(&tt.msg)
+		if bm.Author != tt.author || bm.Text != "hi" {
+			t.Errorf("%s: relayed by %q saying %q", tt.name, bm.Author, bm.Text)
+		}
+
+		if len(bm.Files) != 1 || bm.Files[0].URL != "https://cdn.example.com/a.png" {
+			t.Errorf("%s: files %v", tt.name, bm.Files)
+		}
+	}
+}
+
+func TestDownloadBridged(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, "data")
+	}))
+	defer srv.Close()
+
+	file := func(path string, size int64) *File {
+		return &File{Name: strings.TrimPrefix(path, "/"), Size: size, url: func(context.Context) (string, error) {
+			return srv.URL + path, nil
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		files     []*File
+		uploads   int
+		described bool
+	}{
+		{"none", nil, 0, true},
+		{"downloaded", []*File{file("/a", 4)}, 1, false},
+		{"too large", []*File{file("/a", discordMaxUploadSize+1)}, 0, true},
+		{"failed", []*File{file("/a", 4), file("/missing", 4)}, 1, true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		bm := &BridgeMessage{Attachments: []string{"[photo]"}}
+		c.downloadBridged(bm, tt.files)
+
+		if len(bm.Files) != tt.uploads {
+			t.Errorf("%s: %d files to upload, want %d", tt.name, len(bm.Files), tt.uploads)
+		}
+
+		if described := len(bm.Attachments) > 0; described != tt.described {
+			t.Errorf("%s: still described = %t, want %t", tt.name, described, tt.described)
+		}
+
+		for _, f := range bm.Files {
+			if string(f.Data) != "data" {
+				t.Errorf("%s: uploads %q", tt.name, f.Data)
+			}
+		}
+	}
+}
@@ -2,15 +2,76 @@ package crossbot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// callbackSweepInterval is how often expired callbacks are evicted
+	callbackSweepInterval = time.Minute
+
+	// callbackExpiredRetention is how long expired callbacks are kept, so that
+	// pressing their buttons still tells the user they expired
+	callbackExpiredRetention = time.Hour
+)
+
+// CallbackCache holds registered callbacks by their ID. Callbacks without an
+// expiry are never removed, as buttons of old messages may still be pressed.
+//
+// Deprecated: handlers register and look up callbacks concurrently while
+// holding a lock, so the map must not be accessed directly. Use
+// Callback.Register and LookupCallback instead.
 var CallbackCache = make(map[string]Callback)
 
+// callbackCache guards CallbackCache
+var callbackCache struct {
+	sync.RWMutex
+
+	// Time expired callbacks were last evicted
+	swept time.Time
+}
+
+var (
+	// callbackPrefix starts the IDs of callbacks registered by this process,
+	// so that buttons left from before a restart do not resolve to new ones
+	callbackPrefix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	// callbackID is the number of the last registered callback
+	callbackID atomic.Uint64
+)
+
+// LookupCallback returns the registered callback with the ID
+func LookupCallback(id string) (Callback, bool) {
+	callbackCache.RLock()
+	defer callbackCache.RUnlock()
+
+	cb, ok := CallbackCache[id]
+	return cb, ok
+}
+
+// sweepCallbacks evicts callbacks that expired a while ago, at most once per
+// sweep interval. The caller must hold the lock.
+func sweepCallbacks(now time.Time) {
+	if now.Sub(callbackCache.swept) < callbackSweepInterval {
+		return
+	}
+	callbackCache.swept = now
+
+	for id, cb := range CallbackCache {
+		if !cb.Expires.IsZero() && now.Sub(cb.Expires) > callbackExpiredRetention {
+			delete(CallbackCache, id)
+		}
+	}
+}
+
 // DefaultCacheDirectory creates and returns a temporary directory to store cache
 func (c *Config) DefaultCacheDirectory() (string, error) {
 	dir := filepath.Join(os.TempDir(), c.ID)
@@ -24,6 +85,9 @@ func (c *Config) DefaultCacheDirectory() (string, error) {
 func (c *Config) ReadCache(key string, value any) error {
 	path := filepath.Join(c.CacheDirectory, key+".json")
 	file, err := os.ReadFile(path)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		c.recordCache("file", err == nil)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
@@ -0,0 +1,321 @@
+package crossbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// choicesTimeout is how long numbered choices can be picked after being sent
+const choicesTimeout = 15 * time.Minute
+
+type (
+	// Capabilities describes the features a platform supports. Messages using
+	// unsupported components are degraded instead of losing functionality.
+	Capabilities struct {
+		// Buttons with callbacks. Without them buttons and select menus become
+		// numbered choices picked by replying with their number.
+		Buttons bool
+
+		// Native select menus. Without them each option becomes a button.
+		Selects bool
+
+		// Editing and deleting sent messages
+		Edits bool
+
+		// File uploads. Without them attachments are linked to in the
+		// description when they have a URL and named otherwise.
+		Attachments bool
+	}
+
+	// pendingChoices are the numbered choices last sent to a chat
+	pendingChoices struct {
+		// Callback cache IDs, in the order they are numbered in
+		IDs []string
+
+		// Message the choices were sent with, if known
+		Ref *MessageRef
+
+		Expires time.Time
+	}
+)
+
+var platformCapabilities = map[Platform]Capabilities{
+	PlatformDiscord: {
+		Buttons:     true,
+		Selects:     true,
+		Edits:       true,
+		Attachments: true,
+	},
+	PlatformTelegram: {
+		Buttons:     true,
+		Edits:       true,
+		Attachments: true,
+	},
+	PlatformGuilded: {
+		Edits: true,
+	},
+}
+
+// Capabilities returns the features supported by the platform
+func (p Platform) Capabilities() Capabilities {
+	return platformCapabilities[p]
+}
+
+// Capabilities returns the features supported by the platform, taking the
+// config's overrides into account
+func (c *Config) Capabilities(p Platform) Capabilities {
+	if caps, ok := c.PlatformCapabilities[p]; ok {
+		return caps
+	}
+
+	return p.Capabilities()
+}
+
+// degrade rewrites the message for the platform's capabilities. Select menus
+// become buttons, attachments that cannot be uploaded are listed in the
+// description, and on platforms without buttons the buttons and select
+// options become numbered choices and links. The callback IDs of the choices
+// are returned in order.
+func (c *Config) degrade(m *Message, p Platform) (*Message, []string) {
+	caps := c.Capabilities(p)
+	selects := !caps.Selects && len(m.Selects) > 0
+	attachments := !caps.Attachments && len(m.Attachments) > 0
+	buttons := !caps.Buttons && (len(m.Buttons) > 0 || len(m.Selects) > 0)
+	if !selects && !attachments && !buttons {
+		return m, nil
+	}
+
+	res := *m.forPlatform(p)
+	if attachments {
+		res.Attachments = nil
+		res.RichDescription = appendSection(res.richDescription(), attachmentList(m.Attachments))
+	}
+
+	if !buttons {
+		// Each option becomes its own button
+		res.Buttons = append([][]Button(nil), res.Buttons...)
+		for _, sel := range res.Selects {
+			for _, o := range sel.Options {
+				res.Buttons = append(res.Buttons, []Button{{
+					Label:    o.Label,
+					Emoji:    o.Emoji,
+					Callback: sel.Callback.withFields(map[string]string{"values": o.Value}),
+				}})
+			}
+		}
+		res.Selects = nil
+
+		return &res, nil
+	}
+
+	var (
+		ids   []string
+		links []Span
+		list  []Span
+	)
+
+	add := func(label string, cb Callback) {
+		id := cb.Register()
+		ids = append(ids, id)
+		list = append(list, Plain(fmt.Sprintf("%d. %s\n", len(ids), label)))
+	}
+
+	for _, r := range res.Buttons {
+		for _, b := range r {
+			label := strings.TrimSpace(fmt.Sprintf("%s %s", b.Emoji, b.Label))
+			switch {
+			case !b.visibleOn(p) || b.Disabled:
+			case b.URL != "":
+				links = append(links, Link(label, b.URL), Plain("\n"))
+			case b.Callback.noop():
+			default:
+				add(label, b.Callback)
+			}
+		}
+	}
+
+	for _, sel := range res.Selects {
+		for _, o := range sel.Options {
+			label := strings.TrimSpace(fmt.Sprintf("%s %s", o.Emoji, o.Label))
+			add(label, sel.Callback.withFields(map[string]string{"values": o.Value}))
+		}
+	}
+
+	res.Buttons, res.Selects = nil, nil
+
+	rt := links
+	if len(ids) > 0 {
+		if len(links) > 0 {
+			rt = append(rt, Plain("\n"))
+		}
+
+		rt = append(rt, list...)
+		rt = append(rt, Plain("\n"), Italic("Reply "+numberList(len(ids))))
+	}
+
+	res.RichDescription = appendSection(res.richDescription(), rt)
+	return &res, ids
+}
+
+// appendSection adds a section to the end of the description
+func appendSection(rt, section RichText) RichText {
+	if len(section) == 0 {
+		return rt
+	}
+
+	rt = append(RichText(nil), rt...)
+	if len(rt) > 0 {
+		rt = append(rt, Plain("\n\n"))
+	}
+
+	return append(rt, section...)
+}
+
+// attachmentList links to attachments with a URL and names the others
+func attachmentList(attachments []Attachment) (rt RichText) {
+	for _, a := range attachments {
+		name := a.Name
+		if name == "" {
+			name = a.URL
+		}
+
+		if len(rt) > 0 {
+			rt = append(rt, Plain("\n"))
+		}
+
+		if a.URL != "" {
+			rt = append(rt, Plain("📎 "), Link(name, a.URL))
+		} else {
+			rt = append(rt, Plain("📎 "+name))
+		}
+	}
+
+	return rt
+}
+
+// numberList lists the numbers up to n, i.e. "1, 2 or 3"
+func numberList(n int) string {
+	if n == 1 {
+		return "1"
+	}
+
+	nums := make([]string, n-1)
+	for i := range nums {
+		nums[i] = strconv.Itoa(i + 1)
+	}
+
+	return fmt.Sprintf("%s or %d", strings.Join(nums, ", "), n)
+}
+
+// setChoices replaces the numbered choices of a chat
+func (c *Config) setChoices(target Target, ref *MessageRef, ids []string) {
+	c.choicesMu.Lock()
+	defer c.choicesMu.Unlock()
+
+	if c.choices == nil {
+		c.choices = make(map[Target]*pendingChoices)
+	}
+
+	c.choices[target] = &pendingChoices{IDs: ids, Ref: ref, Expires: time.Now().Add(choicesTimeout)}
+}
+
+// choose runs the callback of the numbered choice picked by the user's reply.
+// False is returned if the reply does not pick any of the chat's choices.
+func (c *Config) choose(ctx context.Context, target Target, user, userID, reply, replyTo string) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(reply))
+	if err != nil {
+		return false
+	}
+
+	c.choicesMu.Lock()
+	pending, ok := c.choices[target]
+	if ok && time.Now().After(pending.Expires) {
+		delete(c.choices, target)
+		ok = false
+	}
+	c.choicesMu.Unlock()
+
+	if !ok || n < 1 || n > len(pending.IDs) {
+		return false
+	}
+
+	cb, ok := LookupCallback(pending.IDs[n-1])
+	c.recordCache("callback", ok)
+	if !ok {
+		return false
+	}
+	c.metric().callbacks.WithLabelValues(platformName(target.Platform), cb.origin.name()).Inc()
+
+	req := newRequest(ctx, target, user, userID)
+	if err := c.callbackAllowed(cb, req); err != nil {
+		if _, err := c.send(ctx, target, &Message{Title: err.Error()}, replyTo); err != nil {
+			c.logger().Error("Failed to handle choice", "error", err)
+		}
+
+		return true
+	}
+
+	// Choices can only be picked once. Results with choices of their own
+	// replace them again once sent.
+	c.choicesMu.Lock()
+	if c.choices[target] == pending {
+		delete(c.choices, target)
+	}
+	c.choicesMu.Unlock()
+
+	msg := c.runCallback(cb, req)
+
+	// Choices that failed or were turned away while busy only tell the user so
+	if msg != nil && msg.notice {
+		if msg.Title != "" {
+			c.reply(ctx, target, userID, replyTo, msg, VisibilityPublic)
+		}
+
+		return true
+	}
+
+	ref := pending.Ref
+	canEdit := ref != nil && c.Capabilities(target.Platform).Edits
+	vis := cb.origin.visibility()
+
+	switch {
+	case cb.Action == CallbackActionDeleteMessage:
+		if canEdit {
+			err = c.Delete(ctx, ref)
+		}
+
+	case msg == nil:
+
+	case cb.Action == CallbackActionEditMessage && canEdit && msg.visibility(vis) == VisibilityPublic:
+		err = c.Edit(ctx, ref, msg)
+
+	default:
+		c.reply(ctx, target, userID, replyTo, msg, vis)
+	}
+	if err != nil {
+		c.logger().Error("Failed to handle choice", "error", err)
+	}
+
+	return true
+}
+
+// promptMessage shows the command a prompt asks the user to complete, for
+// platforms without inline queries
+func promptMessage(p Prompt) *Message {
+	return &Message{Title: "Reply with", RichDescription: RichText{CodeBlock("", promptText(p))}}
+}
+
+// promptText returns the command a prompt asks the user to complete
+func promptText(p Prompt) string {
+	prompt := fmt.Sprintf("%s\n\n", p.Prefix)
+	for _, f := range p.Fields {
+		key := strings.ReplaceAll(strings.ToLower(f.Key), " ", "_")
+		value := strings.ReplaceAll(strings.ToLower(f.Value), " ", "_")
+		prompt += fmt.Sprintf("--%s=\"%s\"\n", key, value)
+	}
+
+	return prompt
+}
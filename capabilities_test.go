@@ -0,0 +1,243 @@
+package crossbot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDegrade(t *testing.T) {
+	noop := func(map[string]string) *Message { return nil }
+	menu := Select{
+		Options:  []SelectOption{{Label: "Red", Value: "red"}, {Label: "Blue", Value: "blue", Emoji: "🔵"}},
+		Callback: Callback{Function: noop},
+	}
+
+	tests := []struct {
+		name        string
+		msg         *Message
+		platform    Platform
+		unchanged   bool
+		buttons     int
+		choices     int
+		description []string
+	}{
+		{
+			name:      "supported",
+			msg:       &Message{Buttons: [][]Button{{{Label: "Go", Callback: Callback{Function: noop}}}}, Selects: []Select{menu}},
+			platform:  PlatformDiscord,
+			unchanged: true,
+		},
+		{
+			name:      "plain",
+			msg:       &Message{Title: "Hello"},
+			platform:  PlatformGuilded,
+			unchanged: true,
+		},
+		{
+			name:     "selects as buttons",
+			msg:      &Message{Buttons: [][]Button{{{Label: "Go", Callback: Callback{Function: noop}}}}, Selects: []Select{menu}},
+			platform: PlatformTelegram,
+			buttons:  3,
+		},
+		{
+			name: "numbered choices",
+			msg: &Message{
+				Description: "Pick one",
+				Buttons: [][]Button{{
+					{Label: "Go", Callback: Callback{Function: noop}},
+					{Label: "Docs", URL: "https://example.com"},
+					{Label: "Off", Disabled: true, Callback: Callback{Function: noop}},
+					{Label: "Nothing"},
+					{Label: "Hidden", Platforms: []Platform{PlatformDiscord}, Callback: Callback{Function: noop}},
+				}},
+				Selects: []Select{menu},
+			},
+			platform:    PlatformGuilded,
+			choices:     3,
+			description: []string{"Pick one", "Docs", "1. Go", "2. Red", "3. 🔵 Blue", "Reply 1, 2 or 3"},
+		},
+		{
+			name:        "single choice",
+			msg:         &Message{Buttons: [][]Button{{{Label: "Go", Callback: Callback{Action: CallbackActionDeleteMessage}}}}},
+			platform:    PlatformGuilded,
+			choices:     1,
+			description: []string{"1. Go", "Reply 1"},
+		},
+		{
+			name:        "only links",
+			msg:         &Message{Buttons: [][]Button{{{Label: "Docs", URL: "https://example.com"}}}},
+			platform:    PlatformGuilded,
+			description: []string{"Docs"},
+		},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		got, ids := c.degrade(tt.msg, tt.platform)
+
+		if tt.unchanged {
+			if got != tt.msg || ids != nil {
+				t.Errorf("%s: message was rewritten", tt.name)
+			}
+
+			continue
+		}
+
+		if got == tt.msg {
+			t.Errorf("%s: message was not copied", tt.name)
+		}
+
+		if len(got.Selects) != 0 {
+			t.Errorf("%s: %d selects left", tt.name, len(got.Selects))
+		}
+
+		var buttons int
+		for _, row := range got.Buttons {
+			buttons += len(row)
+		}
+		if buttons != tt.buttons {
+			t.Errorf("%s: %d buttons, want %d", tt.name, buttons, tt.buttons)
+		}
+
+		if len(ids) != tt.choices {
+			t.Errorf("%s: %d choices, want %d", tt.name, len(ids), tt.choices)
+		}
+
+		for _, id := range ids {
+			if _, ok := LookupCallback(id); !ok {
+				t.Errorf("%s: choice %s was not registered", tt.name, id)
+			}
+		}
+
+		description := got.RichDescription.String()
+		for _, want := range tt.description {
+			if !strings.Contains(description, want) {
+				t.Errorf("%s: description %q does not contain %q", tt.name, description, want)
+			}
+		}
+	}
+}
+
+func TestDegradeSelectValues(t *testing.T) {
+	c := &Config{}
+	msg := &Message{Selects: []Select{{
+		Options:  []SelectOption{{Label: "Red", Value: "red"}},
+		Callback: Callback{Fields: `{"page":"2"}`, Function: func(map[string]string) *Message { return nil }},
+	}}}
+
+	got, _ := c.degrade(msg, PlatformTelegram)
+	if len(got.Buttons) != 1 || len(got.Buttons[0]) != 1 {
+		t.Fatalf("select became buttons %v", got.Buttons)
+	}
+
+	fields, err := got.Buttons[0][0].Callback.ParseFields("user", PlatformTelegram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fields["values"] != "red" || fields["page"] != "2" {
+		t.Errorf("option button has fields %v, want the value and the select's fields", fields)
+	}
+
+	if len(msg.Buttons) != 0 || len(msg.Selects) != 1 {
+		t.Error("degrade modified the original message")
+	}
+}
+
+func TestDegradeAttachments(t *testing.T) {
+	c := &Config{PlatformCapabilities: map[Platform]Capabilities{PlatformDiscord: {Buttons: true, Selects: true}}}
+	msg := &Message{
+		Description: "Files",
+		Attachments: []Attachment{{Name: "report.pdf", URL: "https://example.com/report.pdf"}, {Name: "local.txt"}},
+	}
+
+	got, ids := c.degrade(msg, PlatformDiscord)
+	if len(got.Attachments) != 0 || ids != nil {
+		t.Errorf("attachments kept: %v, choices %v", got.Attachments, ids)
+	}
+
+	description := got.RichDescription.String()
+	for _, want := range []string{"Files", "report.pdf", "local.txt"} {
+		if !strings.Contains(description, want) {
+			t.Errorf("description %q does not contain %q", description, want)
+		}
+	}
+
+	if len(msg.Attachments) != 2 {
+		t.Error("degrade modified the original message")
+	}
+}
+
+func TestNumberList(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "1"},
+		{2, "1 or 2"},
+		{4, "1, 2, 3 or 4"},
+	}
+
+	for _, tt := range tests {
+		if got := numberList(tt.n); got != tt.want {
+			t.Errorf("numberList(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	target := Target{Platform: PlatformGuilded, ChatID: "chat"}
+
+	tests := []struct {
+		name    string
+		reply   string
+		expired bool
+		unknown bool
+		want    int
+	}{
+		{"first", "1", false, false, 1},
+		{"second with spaces", " 2 ", false, false, 2},
+		{"zero", "0", false, false, 0},
+		{"out of range", "3", false, false, 0},
+		{"not a number", "yes", false, false, 0},
+		{"expired", "1", true, false, 0},
+		{"unregistered", "1", false, true, 0},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+
+		ran := 0
+		var ids []string
+		for i := range 2 {
+			ids = append(ids, Callback{Function: func(map[string]string) *Message {
+				ran = i + 1
+				return nil
+			}}.Register())
+		}
+		if tt.unknown {
+			ids[0] = callbackPrefix + ".unknown"
+		}
+
+		c.setChoices(target, nil, ids)
+		if tt.expired {
+			c.choices[target].Expires = time.Now().Add(-time.Second)
+		}
+
+		picked := c.choose(context.Background(), target, "user", "1", tt.reply, "")
+		if picked != (tt.want != 0) {
+			t.Errorf("%s: choose picked = %t, want %t", tt.name, picked, tt.want != 0)
+		}
+
+		if ran != tt.want {
+			t.Errorf("%s: ran choice %d, want %d", tt.name, ran, tt.want)
+		}
+
+		// Picked and expired choices cannot be picked again
+		if _, pending := c.choices[target]; pending != (!picked && !tt.expired) {
+			t.Errorf("%s: choices still pending = %t", tt.name, pending)
+		}
+	}
+}
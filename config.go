@@ -1,10 +1,17 @@
 package crossbot
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -16,6 +23,107 @@ type (
 		DiscordConfig  *DiscordConfig
 
 		CacheDirectory string
+
+		// Named handlers available to scheduled jobs
+		JobHandlers map[string]JobHandler
+
+		// Channel pairs to mirror messages between
+		Bridges []Bridge
+
+		// How to handle messages exceeding platform limits. Defaults to
+		// truncating them.
+		LimitStrategy LimitStrategy
+
+		// Overrides the capabilities of platforms (i.e. to use numbered choices
+		// instead of buttons)
+		PlatformCapabilities map[Platform]Capabilities
+
+		// Middlewares ran around every command and callback, before those of
+		// the command
+		Middlewares []Middleware
+
+		// Platform user IDs of the bot's owners, allowed to run commands
+		// restricted with Access.OwnerOnly
+		Owners map[Platform][]string
+
+		// Optional function mapping a platform user ID to an identity shared
+		// across platforms, so linked accounts share their rate limits
+		Identity func(p Platform, userID string) string
+
+		// Rate limits applied to every command and callback
+		RateLimits []RateLimit
+
+		// Store rate limits in the cache directory, keeping them across restarts
+		PersistRateLimits bool
+
+		// Number of times rate limited or transient failures to send, edit or
+		// delete messages are retried. Defaults to 3, while negative values
+		// disable retries.
+		SendRetries int
+
+		// How long handlers may run before their context is cancelled and the
+		// user is told the command timed out. Defaults to 1 minute, while
+		// negative values disable the timeout. Commands may set their own.
+		HandlerTimeout time.Duration
+
+		// Maximum number of handlers running at once across every platform.
+		// Defaults to unlimited.
+		Workers int
+
+		// Maximum number of requests waiting for a worker with
+		// BackpressureQueue. Defaults to unlimited.
+		QueueSize int
+
+		// What happens to requests while every worker of the config or
+		// command is busy
+		Backpressure Backpressure
+
+		// Logger receiving the bot's structured logs. Defaults to slog.Default.
+		Logger *slog.Logger
+
+		// Options keeping personal data out of the logs
+		LogPrivacy LogPrivacy
+
+		// Address to serve Prometheus metrics on under /metrics (i.e. ":9090").
+		// Disabled if empty, while Config.MetricsHandler can still be mounted
+		// elsewhere.
+		MetricsAddress string
+
+		// Provider of the tracer recording OpenTelemetry spans for received
+		// updates, argument parsing, middlewares, handlers and platform
+		// requests. Defaults to the global provider, which records nothing
+		// unless an SDK is installed (i.e. exporting over OTLP to a local
+		// collector, or to stdout).
+		TracerProvider trace.TracerProvider
+
+		// Context of requests, cancelled once Start returns
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		// Active platform connections, populated once each platform starts
+		discord  atomic.Pointer[discordgo.Session]
+		telegram atomic.Pointer[bot.Bot]
+
+		// Channels closed once each platform is done connecting
+		connectedMu sync.Mutex
+		connected   map[Platform]chan struct{}
+
+		schedulerOnce sync.Once
+		scheduler     *scheduler
+
+		bridges bridgeLinks
+
+		conversationsMu sync.Mutex
+		conversations   map[string]*Command
+		sessions        sessionIndex
+
+		choicesMu sync.Mutex
+		choices   map[Target]*pendingChoices
+
+		limiter rateLimiter
+		workers workerPools
+		metrics metrics
+		outbox  outbox
 	}
 
 	TelegramConfig struct {
@@ -47,6 +155,14 @@ func (c *Config) Validate() error {
 	default:
 	}
 
+	if c.LogPrivacy.HashUserIDs && len(c.LogPrivacy.HashKey) == 0 {
+		return errors.New("log privacy hash key must be specified to hash user IDs")
+	}
+
+	if err := validateRateLimits(c.RateLimits); err != nil {
+		return err
+	}
+
 	if c.CacheDirectory == "" {
 		dir, err := c.DefaultCacheDirectory()
 		if err != nil {
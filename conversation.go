@@ -0,0 +1,456 @@
+package crossbot
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConversationTimeout is how long to wait for an answer if the
+// conversation does not specify a timeout
+const defaultConversationTimeout = 5 * time.Minute
+
+type (
+	// Conversation is a series of follow-up questions asked to the user who
+	// invoked a command. Answers are read from the user's next message in the
+	// same chat or from a button press. On Discord this requires the
+	// MessageContent intent, and Telegram bots in groups must either have
+	// privacy mode disabled or be replied to.
+	Conversation struct {
+		// Questions asked in order
+		Steps []Step
+
+		// Time to wait for each answer. Defaults to 5 minutes.
+		Timeout time.Duration
+
+		// Function to be ran once every step is answered. The fields contain the
+		// command's fields along with every answer under its step's key.
+		Finish func(fields map[string]string) *Message
+	}
+
+	// Step is a single question in a conversation
+	Step struct {
+		// Key the answer is stored under
+		Key string
+
+		// Question asked to the user. The fields contain all previous answers.
+		Prompt func(fields map[string]string) *Message
+
+		// Optional answers presented as buttons. Typed answers are still accepted
+		// unless rejected by Validate.
+		Choices []string
+
+		// Optional function to check the answer. The returned error is shown to
+		// the user and the question is asked again.
+		Validate func(answer string, fields map[string]string) error
+	}
+
+	// session is the persisted state of an ongoing conversation
+	session struct {
+		Command string
+		UserID  string
+		Step    int
+		Fields  map[string]string
+		Target  Target
+		Expires time.Time
+	}
+
+	// sessionIndex tracks the ongoing sessions, so that messages from users
+	// without one are passed on without reading the cache
+	sessionIndex struct {
+		mu sync.Mutex
+
+		// Timeouts of the ongoing sessions by their ID
+		timers map[string]*time.Timer
+
+		// Locks held while a session is read and changed
+		locks map[string]*sessionLock
+
+		restoreOnce sync.Once
+	}
+
+	// sessionLock serializes access to a session, and is removed once no one
+	// holds or waits for it
+	sessionLock struct {
+		sync.Mutex
+		refs int
+	}
+)
+
+// registerConversations indexes commands with conversations by name, so that
+// persisted sessions can find their conversation again
+func (c *Config) registerConversations(cmds *[]*Command) {
+	c.conversationsMu.Lock()
+	defer c.conversationsMu.Unlock()
+
+	if c.conversations == nil {
+		c.conversations = make(map[string]*Command)
+	}
+
+	for _, cmd := range *cmds {
+		if cmd.Conversation != nil {
+			c.conversations[cmd.name()] = cmd
+		}
+	}
+}
+
+// conversationCommand returns the command with the named conversation
+func (c *Config) conversationCommand(name string) (*Command, bool) {
+	c.conversationsMu.Lock()
+	defer c.conversationsMu.Unlock()
+
+	cmd, ok := c.conversations[name]
+	return cmd, ok
+}
+
+// startConversation begins a new session for the invoking user, replacing any
+// ongoing one in the same chat, and returns the first question
+func (c *Config) startConversation(cmd *Command, req *Request) *Message {
+	fields := req.Fields
+	target, err := FieldsTarget(fields)
+	if err != nil {
+		return &Message{Title: "Failed to start conversation", Description: err.Error()}
+	}
+
+	if len(cmd.Conversation.Steps) == 0 {
+		return cmd.Conversation.Finish(fields)
+	}
+
+	s := &session{Command: cmd.name(), UserID: req.UserID, Fields: fields, Target: target}
+	id := sessionID(target, req.User, req.UserID)
+
+	unlock := c.lockSession(id)
+	defer unlock()
+
+	if err := c.saveSession(id, s, cmd.Conversation); err != nil {
+		c.logger().Error("Failed to save conversation", "error", err)
+		return &Message{Title: "Failed to start conversation"}
+	}
+
+	return c.prompt(id, s, cmd.Conversation, "")
+}
+
+// answer applies the user's answer to their session, returning the next
+// question or the conversation's result with the command's visibility. False
+// is returned if the user has no ongoing conversation in the chat.
+func (c *Config) answer(target Target, user, userID, answer string) (*Message, bool) {
+	id := sessionID(target, user, userID)
+	if !c.sessionActive(id) {
+		return nil, false
+	}
+
+	_, cmd, ok := c.loadSession(id)
+	if !ok {
+		return nil, false
+	}
+
+	req := newRequest(c.baseContext(), target, user, userID)
+	req.Command, req.continuation = cmd.name(), true
+
+	// Answers run through the command's middlewares like any request, with
+	// prompts, validation and the result guarded like handlers. Only starting
+	// the conversation counts towards its rate limits.
+	msg := c.guard(cmd, c.chain(cmd, func(*Request) *Message {
+		unlock := c.lockSession(id)
+		defer unlock()
+
+		// The session may have changed while waiting for the lock
+		s, cmd, ok := c.loadSession(id)
+		if !ok {
+			return &Message{Title: "This conversation has ended"}
+		}
+
+		return c.applyAnswer(id, s, cmd, answer)
+	}))(req)
+	if msg != nil {
+		msg.Visibility = msg.visibility(cmd.Visibility)
+	}
+
+	return msg, true
+}
+
+// applyAnswer moves the session on with the answer, returning the next
+// question or the conversation's result
+func (c *Config) applyAnswer(id string, s *session, cmd *Command, answer string) *Message {
+	conv := cmd.Conversation
+
+	answer = strings.TrimSpace(answer)
+	if strings.HasPrefix(strings.ToLower(answer), "/cancel") {
+		c.endSession(id)
+		return &Message{Title: "Conversation cancelled"}
+	}
+
+	step := conv.Steps[s.Step]
+	if step.Validate != nil {
+		if err := step.Validate(answer, s.Fields); err != nil {
+			if err := c.saveSession(id, s, conv); err != nil {
+				c.logger().Error("Failed to save conversation", "error", err)
+			}
+
+			return c.prompt(id, s, conv, err.Error())
+		}
+	}
+
+	s.Fields[step.Key] = answer
+	s.Step++
+
+	if s.Step >= len(conv.Steps) {
+		c.endSession(id)
+		return conv.Finish(s.Fields)
+	}
+
+	if err := c.saveSession(id, s, conv); err != nil {
+		c.logger().Error("Failed to save conversation", "error", err)
+		return &Message{Title: "Failed to save answer"}
+	}
+
+	return c.prompt(id, s, conv, "")
+}
+
+// prompt renders the session's current question, with an optional error
+// explaining why the previous answer was rejected
+func (c *Config) prompt(id string, s *session, conv *Conversation, problem string) *Message {
+	step := conv.Steps[s.Step]
+
+	var msg *Message
+	if step.Prompt != nil {
+		msg = step.Prompt(s.Fields)
+	}
+	if msg == nil {
+		msg = &Message{Title: step.Key}
+	}
+
+	if problem != "" {
+		msg.Content = strings.TrimSpace(fmt.Sprintf("⚠️ %s\n%s", problem, msg.Content))
+	}
+
+	var row []Button
+	for _, choice := range step.Choices {
+		fields, _ := json.Marshal(map[string]string{"session": id, "answer": choice})
+		row = append(row, Button{
+			Label: choice,
+			Callback: Callback{
+				Action:   CallbackActionEditMessage,
+				Fields:   string(fields),
+				Function: c.answerCallback,
+				OwnerID:  s.UserID,
+
+				continuation: true,
+			},
+		})
+	}
+
+	if len(row) > 0 {
+		msg.Buttons = append(msg.Buttons, row)
+	}
+
+	return msg
+}
+
+// answerCallback answers a conversation through one of its choice buttons
+func (c *Config) answerCallback(fields map[string]string) *Message {
+	id := fields["session"]
+
+	unlock := c.lockSession(id)
+	defer unlock()
+
+	s, cmd, ok := c.loadSession(id)
+	if !ok {
+		return &Message{Title: "This conversation has ended"}
+	}
+
+	// Only the user who started the conversation may answer it
+	if s.UserID != fields["user_id"] {
+		return c.prompt(id, s, cmd.Conversation, "")
+	}
+
+	// Callbacks already run guarded
+	return c.applyAnswer(id, s, cmd, fields["answer"])
+}
+
+// loadSession returns the ongoing session and the command it belongs to
+func (c *Config) loadSession(id string) (*session, *Command, bool) {
+	var s session
+	if err := c.ReadCache(sessionCacheKey(id), &s); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger().Error("Failed to read conversation", "error", err)
+		}
+
+		return nil, nil, false
+	}
+
+	cmd, ok := c.conversationCommand(s.Command)
+	if !ok || time.Now().After(s.Expires) || s.Step >= len(cmd.Conversation.Steps) {
+		c.endSession(id)
+		return nil, nil, false
+	}
+
+	return &s, cmd, true
+}
+
+// saveSession persists the session and restarts its timeout
+func (c *Config) saveSession(id string, s *session, conv *Conversation) error {
+	timeout := conv.Timeout
+	if timeout <= 0 {
+		timeout = defaultConversationTimeout
+	}
+	s.Expires = time.Now().Add(timeout)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	if err := c.WriteCache(sessionCacheKey(id), data); err != nil {
+		return err
+	}
+
+	c.armSession(id, s.Target, timeout)
+	return nil
+}
+
+// armSession (re)starts the session's timeout, telling the chat once it
+// passes unless the session was answered in the meantime
+func (c *Config) armSession(id string, target Target, timeout time.Duration) {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+
+	if c.sessions.timers == nil {
+		c.sessions.timers = make(map[string]*time.Timer)
+	}
+
+	if t, ok := c.sessions.timers[id]; ok {
+		t.Stop()
+	}
+
+	c.sessions.timers[id] = time.AfterFunc(timeout, func() {
+		if _, _, ok := c.loadSession(id); ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, err := c.Send(ctx, target, &Message{Title: "Conversation timed out"}); err != nil {
+			c.logger().Error("Failed to send conversation timeout", "error", err)
+		}
+	})
+}
+
+// sessionActive reports whether the session is ongoing, which is the case
+// while its timeout is armed
+func (c *Config) sessionActive(id string) bool {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+
+	_, ok := c.sessions.timers[id]
+	return ok
+}
+
+// lockSession waits for and takes the session's lock, returning a function
+// releasing it
+func (c *Config) lockSession(id string) (unlock func()) {
+	c.sessions.mu.Lock()
+	if c.sessions.locks == nil {
+		c.sessions.locks = make(map[string]*sessionLock)
+	}
+
+	l, ok := c.sessions.locks[id]
+	if !ok {
+		l = &sessionLock{}
+		c.sessions.locks[id] = l
+	}
+	l.refs++
+	c.sessions.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		c.sessions.mu.Lock()
+		defer c.sessions.mu.Unlock()
+
+		if l.refs--; l.refs == 0 {
+			delete(c.sessions.locks, id)
+		}
+	}
+}
+
+// restoreSessions arms the timeouts of sessions persisted before a restart.
+// Sessions that expired while the bot was down time out right away.
+func (c *Config) restoreSessions() {
+	c.sessions.restoreOnce.Do(func() {
+		paths, err := filepath.Glob(filepath.Join(c.CacheDirectory, sessionCacheKey("*")+".json"))
+		if err != nil {
+			c.logger().Error("Failed to list conversations", "error", err)
+			return
+		}
+
+		for _, path := range paths {
+			id := strings.TrimPrefix(strings.TrimSuffix(filepath.Base(path), ".json"), sessionCacheKey(""))
+
+			var s session
+			if err := c.ReadCache(sessionCacheKey(id), &s); err != nil {
+				c.logger().Error("Failed to read conversation", "error", err)
+				continue
+			}
+
+			if _, ok := c.conversationCommand(s.Command); !ok {
+				continue
+			}
+
+			c.armSession(id, s.Target, max(time.Until(s.Expires), 0))
+		}
+	})
+}
+
+// endSession removes the session and stops its timeout
+func (c *Config) endSession(id string) {
+	c.sessions.mu.Lock()
+	if t, ok := c.sessions.timers[id]; ok {
+		t.Stop()
+		delete(c.sessions.timers, id)
+	}
+	c.sessions.mu.Unlock()
+
+	path := filepath.Join(c.CacheDirectory, sessionCacheKey(id)+".json")
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.logger().Error("Failed to remove conversation", "error", err)
+	}
+}
+
+// sessionID identifies a user's conversation in a chat by their ID. Requests
+// without one, such as those created through Run, fall back to the name.
+func sessionID(target Target, user, userID string) string {
+	if userID == "" {
+		userID = "name:" + user
+	}
+
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d:%s:%s:%s", target.Platform, target.ChatID, target.ThreadID, userID)))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionCacheKey(id string) string {
+	return "session-" + id
+}
+
+// name returns the name a command is referred to by
+func (cmd *Command) name() string {
+	if cmd == nil {
+		return ""
+	}
+
+	if len(cmd.Text.Aliases) > 0 {
+		return cmd.Text.Aliases[0]
+	}
+
+	return cmd.Discord.ApplicationCommand.Name
+}
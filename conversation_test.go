@@ -0,0 +1,187 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConversation asks for a name and an age, the latter validated and
+// offered as choices
+func testConversation() *Command {
+	return &Command{
+		Text: TextCommand{Aliases: []string{"signup"}},
+		Conversation: &Conversation{
+			Timeout: time.Hour,
+			Steps: []Step{
+				{
+					Key:    "name",
+					Prompt: func(map[string]string) *Message { return &Message{Title: "Name?"} },
+				},
+				{
+					Key:     "age",
+					Choices: []string{"18", "30"},
+					Prompt: func(fields map[string]string) *Message {
+						return &Message{Title: "Age, " + fields["name"] + "?"}
+					},
+					Validate: func(answer string, _ map[string]string) error {
+						if strings.Trim(answer, "0123456789") != "" || answer == "" {
+							return errors.New("not a number")
+						}
+
+						return nil
+					},
+				},
+				{Key: "nickname"},
+			},
+			Finish: func(fields map[string]string) *Message {
+				return &Message{Title: "Done", Description: fields["name"] + " " + fields["age"] + " " + fields["nickname"]}
+			},
+		},
+	}
+}
+
+func TestConversationSteps(t *testing.T) {
+	target := Target{Platform: PlatformTelegram, ChatID: "chat"}
+
+	tests := []struct {
+		name    string
+		answers []string
+		titles  []string
+		content string
+		buttons int
+		ongoing bool
+	}{
+		{"first step", nil, []string{"Name?"}, "", 0, true},
+		{"second step", []string{"Ann"}, []string{"Name?", "Age, Ann?"}, "", 2, true},
+		{"rejected answer", []string{"Ann", "old"}, []string{"Name?", "Age, Ann?", "Age, Ann?"}, "⚠️ not a number", 2, true},
+		{"missing prompt", []string{"Ann", "30"}, []string{"Name?", "Age, Ann?", "nickname"}, "", 0, true},
+		{"finished", []string{" Ann ", "old", "30", "annie"}, []string{"Name?", "Age, Ann?", "Age, Ann?", "nickname", "Done"}, "", 0, false},
+		{"cancelled", []string{"Ann", "/cancel"}, []string{"Name?", "Age, Ann?", "Conversation cancelled"}, "", 0, false},
+	}
+
+	for _, tt := range tests {
+		c := &Config{CacheDirectory: t.TempDir()}
+		cmd := testConversation()
+		c.registerConversations(&[]*Command{cmd})
+
+		req := newRequest(context.Background(), target, "ann", "1")
+		msg := c.startConversation(cmd, req)
+		titles := []string{msg.Title}
+
+		for _, a := range tt.answers {
+			var ok bool
+			msg, ok = c.answer(target, "ann", "1", a)
+			if !ok {
+				t.Fatalf("%s: answer %q found no conversation", tt.name, a)
+			}
+			titles = append(titles, msg.Title)
+		}
+
+		if strings.Join(titles, "|") != strings.Join(tt.titles, "|") {
+			t.Errorf("%s: asked %q, want %q", tt.name, titles, tt.titles)
+		}
+
+		if msg.Content != tt.content {
+			t.Errorf("%s: last message content %q, want %q", tt.name, msg.Content, tt.content)
+		}
+
+		var buttons int
+		for _, row := range msg.Buttons {
+			buttons += len(row)
+		}
+		if buttons != tt.buttons {
+			t.Errorf("%s: last message has %d buttons, want %d", tt.name, buttons, tt.buttons)
+		}
+
+		id := sessionID(target, "ann", "1")
+		if ongoing := c.sessionActive(id); ongoing != tt.ongoing {
+			t.Errorf("%s: conversation ongoing = %t, want %t", tt.name, ongoing, tt.ongoing)
+		}
+		c.endSession(id)
+	}
+}
+
+func TestConversationResult(t *testing.T) {
+	target := Target{Platform: PlatformDiscord, ChatID: "chat"}
+	c := &Config{CacheDirectory: t.TempDir()}
+	cmd := testConversation()
+	c.registerConversations(&[]*Command{cmd})
+
+	c.startConversation(cmd, newRequest(context.Background(), target, "ann", "1"))
+
+	var msg *Message
+	for _, a := range []string{"Ann", "30", "annie"} {
+		msg, _ = c.answer(target, "ann", "1", a)
+	}
+
+	if msg.Description != "Ann 30 annie" {
+		t.Errorf("conversation finished with %q, want the trimmed answers", msg.Description)
+	}
+
+	if _, ok := c.answer(target, "ann", "1", "again"); ok {
+		t.Error("finished conversation still takes answers")
+	}
+}
+
+func TestConversationOtherUsers(t *testing.T) {
+	target := Target{Platform: PlatformTelegram, ChatID: "chat"}
+	c := &Config{CacheDirectory: t.TempDir()}
+	cmd := testConversation()
+	c.registerConversations(&[]*Command{cmd})
+
+	c.startConversation(cmd, newRequest(context.Background(), target, "ann", "1"))
+	defer c.endSession(sessionID(target, "ann", "1"))
+
+	if _, ok := c.answer(target, "bob", "2", "Bob"); ok {
+		t.Error("another user answered the conversation")
+	}
+
+	if _, ok := c.answer(Target{Platform: PlatformTelegram, ChatID: "other"}, "ann", "1", "Ann"); ok {
+		t.Error("the conversation was answered from another chat")
+	}
+}
+
+func TestConversationChoice(t *testing.T) {
+	target := Target{Platform: PlatformTelegram, ChatID: "chat"}
+	c := &Config{CacheDirectory: t.TempDir()}
+	cmd := testConversation()
+	c.registerConversations(&[]*Command{cmd})
+
+	c.startConversation(cmd, newRequest(context.Background(), target, "ann", "1"))
+	msg, _ := c.answer(target, "ann", "1", "Ann")
+	defer c.endSession(sessionID(target, "ann", "1"))
+
+	cb := msg.Buttons[0][1].Callback
+	if !cb.continuation || cb.OwnerID != "1" {
+		t.Errorf("choice callback continuation = %t, owner %q", cb.continuation, cb.OwnerID)
+	}
+
+	fields, err := cb.ParseFields("bob", PlatformTelegram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the user who started the conversation moves it on
+	fields["user_id"] = "2"
+	if got := cb.Function(fields); got.Title != "Age, Ann?" {
+		t.Errorf("another user's choice was answered with %q", got.Title)
+	}
+
+	fields["user_id"] = "1"
+	if got := cb.Function(fields); got.Title != "nickname" {
+		t.Errorf("choice was answered with %q, want the next step", got.Title)
+	}
+}
+
+func TestConversationWithoutSteps(t *testing.T) {
+	c := &Config{CacheDirectory: t.TempDir()}
+	cmd := &Command{Conversation: &Conversation{Finish: func(map[string]string) *Message { return &Message{Title: "Done"} }}}
+
+	req := newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "chat"}, "ann", "1")
+	if msg := c.startConversation(cmd, req); msg.Title != "Done" {
+		t.Errorf("conversation without steps asked %q", msg.Title)
+	}
+}
@@ -0,0 +1,227 @@
+package crossbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard 5-field cron expression. Each field is a
+// bitset of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+}
+
+type cronBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronBounds{min: 0, max: 59}
+	cronHour   = cronBounds{min: 0, max: 23}
+	cronDom    = cronBounds{min: 1, max: 31}
+	cronMonth  = cronBounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronBounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression in the form
+// "minute hour day-of-month month day-of-week" or one of the @ descriptors
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression '%s', got %d", spec, len(parts))
+	}
+
+	var (
+		s   cronSchedule
+		err error
+	)
+
+	if s.minute, err = parseCronField(parts[0], cronMinute); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if s.hour, err = parseCronField(parts[1], cronHour); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if s.dom, err = parseCronField(parts[2], cronDom); err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field: %w", err)
+	}
+	if s.month, err = parseCronField(parts[3], cronMonth); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+
+	if s.dow, err = parseCronField(parts[4], cronDow); err != nil {
+		return nil, fmt.Errorf("invalid day-of-week field: %w", err)
+	}
+
+	// Both 0 and 7 mean Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+
+	return &s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// (i.e. "*/15", "1-5", "mon,wed,fri") into a bitset
+func parseCronField(field string, b cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", part[i+1:])
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*":
+			lo, hi = b.min, b.max
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = b.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = b.value(bounds[1]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if lo, err = b.value(rng); err != nil {
+				return 0, err
+			}
+
+			// A single value with a step runs until the end of the range
+			hi = lo
+			if step > 1 {
+				hi = b.max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range '%s'", rng)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (b cronBounds) value(s string) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+
+	return v, nil
+}
+
+// next returns the first time after t matching the schedule, in t's location.
+// The zero time is returned if nothing matches within the next five years.
+// Times skipped by daylight saving changes do not run, while repeated times
+// only run once.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	start := wallClock(t)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Adding minutes, rather than building the time, lands on the
+			// first of repeated hours
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 || wallClock(t) < start {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// wallClock returns the time as shown on the clock, ignoring its offset, in
+// a form that sorts chronologically
+func wallClock(t time.Time) string {
+	return t.Format("2006-01-02 15:04")
+}
+
+// matchDay follows the usual cron semantics: if both day-of-month and
+// day-of-week are restricted, either one matching is enough
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domAll := s.dom == fullCronField(cronDom)
+	dowAll := s.dow == fullCronField(cronBounds{min: 0, max: 6})
+
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case domAll && dowAll:
+		return true
+	case domAll:
+		return dowMatch
+	case dowAll:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+func fullCronField(b cronBounds) (bits uint64) {
+	for v := b.min; v <= b.max; v++ {
+		bits |= 1 << uint(v)
+	}
+
+	return bits
+}
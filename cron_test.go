@@ -0,0 +1,220 @@
+package crossbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field  string
+		bounds cronBounds
+		want   []int
+	}{
+		{"*", cronHour, rangeOf(0, 23)},
+		{"0", cronMinute, []int{0}},
+		{"59", cronMinute, []int{59}},
+		{"1-5", cronDow, []int{1, 2, 3, 4, 5}},
+		{"*/15", cronMinute, []int{0, 15, 30, 45}},
+		{"10-20/5", cronMinute, []int{10, 15, 20}},
+		{"5/20", cronMinute, []int{5, 25, 45}},
+		{"1,15,31", cronDom, []int{1, 15, 31}},
+		{"1-3,10-11", cronMonth, []int{1, 2, 3, 10, 11}},
+		{"mon,wed,fri", cronDow, []int{1, 3, 5}},
+		{"JAN-mar", cronMonth, []int{1, 2, 3}},
+		{"*/2", cronDom, []int{1, 3, 5, 7, 9, 11, 13, 15, 17, 19, 21, 23, 25, 27, 29, 31}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.bounds)
+		if err != nil {
+			t.Errorf("parseCronField(%q) returned error: %v", tt.field, err)
+			continue
+		}
+
+		if want := bitsOf(tt.want); got != want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, want)
+		}
+	}
+}
+
+func TestParseCronFieldInvalid(t *testing.T) {
+	tests := []struct {
+		field  string
+		bounds cronBounds
+	}{
+		{"60", cronMinute},
+		{"-1", cronMinute},
+		{"24", cronHour},
+		{"0", cronDom},
+		{"32", cronDom},
+		{"0", cronMonth},
+		{"13", cronMonth},
+		{"8", cronDow},
+		{"5-1", cronHour},
+		{"*/0", cronMinute},
+		{"*/x", cronMinute},
+		{"1,,2", cronMinute},
+		{"foo", cronMonth},
+		{"", cronMinute},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseCronField(tt.field, tt.bounds); err == nil {
+			t.Errorf("parseCronField(%q) succeeded, want error", tt.field)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"0 0 1 1 *", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"0 9 * * 7", false},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"@never", true},
+		{"0 24 * * *", true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseCron(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+		}
+	}
+
+	// Both 0 and 7 mean Sunday
+	s, err := parseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.dow != 1 {
+		t.Errorf("day-of-week 7 parsed as %b, want Sunday only", s.dow)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-01-01 10:00", "2024-01-01 10:01"},
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"0 * * * *", "2024-01-01 10:00", "2024-01-01 11:00"},
+		{"30 9 * * *", "2024-01-01 10:00", "2024-01-02 09:30"},
+		{"0 0 1 * *", "2024-01-15 12:00", "2024-02-01 00:00"},
+		{"0 0 31 * *", "2024-02-01 00:00", "2024-03-31 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 * * mon-fri", "2024-01-05 13:00", "2024-01-08 12:00"},
+		{"0 0 * * 0", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"0 0 1,15 * *", "2024-01-02 00:00", "2024-01-15 00:00"},
+		{"@yearly", "2024-06-01 00:00", "2025-01-01 00:00"},
+
+		// Day-of-month or day-of-week when both are restricted
+		{"0 0 13 * fri", "2024-01-01 00:00", "2024-01-05 00:00"},
+		{"0 0 13 * fri", "2024-01-12 01:00", "2024-01-13 00:00"},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q) returned error: %v", tt.spec, err)
+		}
+
+		got := s.next(parseTime(t, tt.from, time.UTC))
+		if want := parseTime(t, tt.want, time.UTC); !got.Equal(want) {
+			t.Errorf("%q after %s = %s, want %s", tt.spec, tt.from, got, want)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data unavailable:", err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want []string
+	}{
+		{
+			// 02:00 to 03:00 does not exist on 31 March 2024
+			name: "skipped time does not run",
+			spec: "30 2 * * *",
+			from: time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want: []string{"2024-04-01T00:30:00Z", "2024-04-02T00:30:00Z"},
+		},
+		{
+			name: "times around the gap keep their wall clock",
+			spec: "30 1,3 * * *",
+			from: time.Date(2024, 3, 31, 0, 0, 0, 0, berlin),
+			want: []string{"2024-03-31T00:30:00Z", "2024-03-31T01:30:00Z"},
+		},
+		{
+			// 02:00 to 03:00 happens twice on 27 October 2024
+			name: "repeated time runs once",
+			spec: "30 2 * * *",
+			from: time.Date(2024, 10, 27, 0, 0, 0, 0, berlin),
+			want: []string{"2024-10-27T00:30:00Z", "2024-10-28T01:30:00Z"},
+		},
+		{
+			name: "hourly runs through the repeated hour once",
+			spec: "0 * * * *",
+			from: time.Date(2024, 10, 27, 1, 30, 0, 0, berlin),
+			want: []string{"2024-10-27T00:00:00Z", "2024-10-27T02:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: parseCron(%q) returned error: %v", tt.name, tt.spec, err)
+		}
+
+		from := tt.from
+		for i, want := range tt.want {
+			got := s.next(from).UTC().Format(time.RFC3339)
+			if got != want {
+				t.Errorf("%s: run %d = %s, want %s", tt.name, i, got, want)
+			}
+			from = s.next(from)
+		}
+	}
+}
+
+func rangeOf(lo, hi int) (values []int) {
+	for v := lo; v <= hi; v++ {
+		values = append(values, v)
+	}
+
+	return values
+}
+
+func bitsOf(values []int) (bits uint64) {
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+
+	return bits
+}
+
+func parseTime(t *testing.T, s string, loc *time.Location) time.Time {
+	t.Helper()
+
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
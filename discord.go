@@ -1,10 +1,12 @@
 package crossbot
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (c *Config) Discord(cmds *[]*Command) (cancelFunc func() error, err error) {
@@ -18,7 +20,7 @@ func (c *Config) Discord(cmds *[]*Command) (cancelFunc func() error, err error)
 	// Register all commands to Discord
 	dg.AddHandler(func(s *discordgo.Session, m *discordgo.Ready) {
 		if err = c.RegisterDiscord(s, cmds); err != nil {
-			log.Println("Failed to register Discord commands:", err)
+			c.logger().Error("Failed to register Discord commands", "error", err)
 		}
 	})
 
@@ -34,7 +36,7 @@ func (c *Config) Discord(cmds *[]*Command) (cancelFunc func() error, err error)
 
 	// Logger
 	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		log.Printf("%s (%s): %s", m.Author.Username, m.Author.ID, m.Content)
+		c.logMessage(Target{Platform: PlatformDiscord, ChatID: m.ChannelID}, m.Author.Username, m.Author.ID, m.Content)
 	})
 
 	for _, cmd := range *cmds {
@@ -43,16 +45,42 @@ func (c *Config) Discord(cmds *[]*Command) (cancelFunc func() error, err error)
 		}
 	}
 
+	c.registerDiscordBridges(dg)
+	c.registerConversations(cmds)
+
+	// Numbered choices and conversation answers
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		defer c.recoverPanic("Discord message handler")
+
+		if m.Author == nil || m.Author.Bot {
+			return
+		}
+
+		target := Target{Platform: PlatformDiscord, ChatID: m.ChannelID}
+		ctx, span := c.startSpan(c.baseContext(), "update", target, attribute.String("crossbot.update", "message"))
+		defer span.End()
+
+		if c.choose(ctx, target, m.Author.Username, m.Author.ID, m.Content, m.ID) {
+			return
+		}
+
+		if msg, ok := c.answer(target, m.Author.Username, m.Author.ID, m.Content); ok {
+			c.reply(ctx, target, m.Author.ID, m.ID, msg, VisibilityPublic)
+		}
+	})
+
 	if err = dg.Open(); err != nil {
 		return nil, fmt.Errorf("failed to establish Discord connection: %w", err)
 	}
+	c.discord.Store(dg)
+	c.connectDone(PlatformDiscord)
 
 	return dg.Close, nil
 }
 
 // RegisterDiscord registers all provided commands with Discord
 func (c *Config) RegisterDiscord(s *discordgo.Session, cmds *[]*Command) error {
-	commandHandlers := make(map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate))
+	commandHandlers := make(map[string]func(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate))
 
 	var dcmds []*discordgo.ApplicationCommand
 
@@ -64,6 +92,7 @@ func (c *Config) RegisterDiscord(s *discordgo.Session, cmds *[]*Command) error {
 
 		cmdCpy := cmd
 		dcmd := cmdCpy.Discord.ApplicationCommand
+		cmdCpy.Access.applyDiscordAccess(&dcmd)
 		dcmds = append(dcmds, &dcmd)
 	}
 
@@ -103,64 +132,98 @@ func (c *Config) RegisterDiscord(s *discordgo.Session, cmds *[]*Command) error {
 		cmdCpy := cmd
 		dcmd := cmdCpy.Discord.ApplicationCommand
 
-		commandHandlers[dcmd.Name] = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			fields := make(map[string]string)
-			fields["user"] = i.User.Username
-			fields["platform"] = fmt.Sprint(PlatformDiscord)
-
-			for _, opt := range i.ApplicationCommandData().Options {
-				fields[opt.Name] = opt.StringValue()
+		commandHandlers[dcmd.Name] = func(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) {
+			user := interactionUser(i)
+			req := newRequest(ctx, Target{Platform: PlatformDiscord, ChatID: i.ChannelID}, user.Username, user.ID)
+			req.setDiscordInteraction(i.Interaction)
+
+			_, span := c.startSpan(ctx, "parse", req.Target)
+			data := i.ApplicationCommandData()
+			for _, opt := range data.Options {
+				if opt.Type != discordgo.ApplicationCommandOptionAttachment {
+					req.Fields[opt.Name] = fmt.Sprint(opt.Value)
+					continue
+				}
+
+				if data.Resolved == nil {
+					continue
+				}
+
+				if a, ok := data.Resolved.Attachments[fmt.Sprint(opt.Value)]; ok {
+					req.Files[opt.Name] = discordFile(a)
+				}
 			}
-
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: cmdCpy.Handler(fields).Discord(),
-			})
-			if err != nil {
-				log.Println("Failed to respond to Discord interaction:", err)
+			span.End()
+
+			r := &discordResponder{
+				ctx:        ctx,
+				c:          c,
+				s:          s,
+				i:          i.Interaction,
+				target:     req.Target,
+				userID:     user.ID,
+				visibility: cmdCpy.Visibility,
 			}
+			req.progress = r.progress
+			r.run(func() *Message { return c.handle(cmdCpy, req) })
 		}
 	}
 
 	s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		defer c.recoverPanic("Discord interaction handler")
+
+		ctx, span := c.startSpan(c.baseContext(), "update", Target{Platform: PlatformDiscord, ChatID: i.ChannelID},
+			attribute.String("crossbot.update", i.Type.String()),
+		)
+		defer span.End()
+
 		switch i.Type {
 		case discordgo.InteractionApplicationCommand:
 			if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-				h(s, i)
+				h(ctx, s, i)
 			}
 		case discordgo.InteractionMessageComponent:
 			id := i.Interaction.MessageComponentData().CustomID
-			cb, ok := CallbackCache[id]
+			cb, ok := LookupCallback(id)
+			c.recordCache("callback", ok)
 			if !ok {
 				return
 			}
+			c.metric().callbacks.WithLabelValues(platformName(PlatformDiscord), cb.origin.name()).Inc()
 
-			user := i.User.Username
-			msg := cb.Run(user, PlatformDiscord)
-			resp := msg.Discord()
-
-			switch cb.Action {
-			case CallbackActionEditMessage:
-				s.ChannelMessageEditComplex(&discordgo.MessageEdit{
-					ID:         i.Message.ID,
-					Channel:    i.ChannelID,
-					Embeds:     &resp.Embeds,
-					Components: &resp.Components,
-				})
+			// Selected menu options
+			if values := i.MessageComponentData().Values; len(values) > 0 {
+				cb = cb.withFields(map[string]string{"values": strings.Join(values, ",")})
+			}
 
-			case CallbackActionCreateMessage:
-				s.ChannelMessageSendComplex(i.ChannelID, &discordgo.MessageSend{
-					Embeds:     resp.Embeds,
-					Components: resp.Components,
-				})
+			user := interactionUser(i)
+			ref := &MessageRef{Target: Target{Platform: PlatformDiscord, ChatID: i.ChannelID}, MessageID: i.Message.ID}
 
-			case CallbackActionDeleteMessage:
-				s.ChannelMessageDelete(i.ChannelID, i.Message.ID)
+			req := newRequest(ctx, ref.Target, user.Username, user.ID)
+			req.setDiscordInteraction(i.Interaction)
+
+			if err := c.callbackAllowed(cb, req); err != nil {
+				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionResponseChannelMessageWithSource,
+					Data: &discordgo.InteractionResponseData{
+						Content: err.Error(),
+						Flags:   discordgo.MessageFlagsEphemeral,
+					},
+				})
+				return
 			}
 
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseDeferredMessageUpdate,
-			})
+			r := &discordResponder{
+				ctx:        ctx,
+				c:          c,
+				s:          s,
+				i:          i.Interaction,
+				target:     ref.Target,
+				userID:     user.ID,
+				visibility: cb.origin.visibility(),
+				component:  true,
+			}
+			r.runComponent(cb, ref, func() *Message { return c.runCallback(cb, req) })
 
 		case discordgo.InteractionModalSubmit:
 		}
@@ -168,3 +231,38 @@ func (c *Config) RegisterDiscord(s *discordgo.Session, cmds *[]*Command) error {
 
 	return nil
 }
+
+// discordFile exposes an attachment option's file to the handler
+func discordFile(a *discordgo.MessageAttachment) *File {
+	return &File{
+		Name:        a.Filename,
+		ContentType: a.ContentType,
+		Size:        int64(a.Size),
+		url: func(context.Context) (string, error) {
+			return a.URL, nil
+		},
+	}
+}
+
+// discordPrivate checks whether the message of the component interaction is
+// only visible to the user, so a result with the visibility can replace it
+func discordPrivate(i *discordgo.Interaction, vis Visibility) bool {
+	switch {
+	case i.GuildID == "":
+		return true
+	case i.Message != nil && i.Message.Flags&discordgo.MessageFlagsEphemeral != 0:
+		return vis != VisibilityPrivate
+	default:
+		return vis == VisibilityPublic
+	}
+}
+
+// interactionUser returns the user who triggered an interaction, which is only
+// set on the member when the interaction happened in a guild
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+
+	return i.User
+}
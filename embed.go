@@ -0,0 +1,204 @@
+package crossbot
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/itschip/guildedgo"
+)
+
+// telegramTimeLayout is used to show embed timestamps on Telegram, which has
+// no native timestamp rendering
+const telegramTimeLayout = "2 Jan 2006 15:04 MST"
+
+// embed returns the message's own embed
+func (m *Message) embed() Embed {
+	return Embed{
+		Title:             m.Title,
+		Description:       m.Description,
+		RichDescription:   m.RichDescription,
+		URL:               m.URL,
+		Color:             m.Color,
+		Author:            m.Author,
+		ThumbnailImageURL: m.ThumbnailImageURL,
+		ImageURL:          m.ImageURL,
+		Footer:            m.Footer,
+		Timestamp:         m.Timestamp,
+		Fields:            m.Fields,
+	}
+}
+
+// embeds returns every embed of the message in order, leaving out the
+// message's own embed if it is empty
+func (m *Message) embeds() []Embed {
+	var res []Embed
+	if e := m.embed(); !e.empty() {
+		res = append(res, e)
+	}
+
+	return append(res, m.Embeds...)
+}
+
+// empty reports whether the embed has nothing to show
+func (e Embed) empty() bool {
+	return e.Title == "" && e.descriptionText() == "" && e.Author.Name == "" &&
+		e.ThumbnailImageURL == "" && e.ImageURL == "" && e.Footer.Text == "" &&
+		e.Timestamp.IsZero() && len(e.Fields) == 0
+}
+
+// description returns the description as Discord markdown
+func (e Embed) description() string {
+	if e.RichDescription != nil {
+		return e.RichDescription.Discord()
+	}
+
+	return e.Description
+}
+
+// descriptionText returns the description without formatting
+func (e Embed) descriptionText() string {
+	if e.RichDescription != nil {
+		return e.RichDescription.String()
+	}
+
+	return e.Description
+}
+
+func (e Embed) discord() *discordgo.MessageEmbed {
+	res := &discordgo.MessageEmbed{
+		URL:         e.URL,
+		Title:       e.Title,
+		Description: e.description(),
+		Color:       e.Color,
+	}
+
+	if e.Author.Name != "" {
+		res.Author = &discordgo.MessageEmbedAuthor{Name: e.Author.Name, URL: e.Author.URL, IconURL: e.Author.IconURL}
+	}
+
+	if e.ThumbnailImageURL != "" {
+		res.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: e.ThumbnailImageURL}
+	}
+
+	if e.ImageURL != "" {
+		res.Image = &discordgo.MessageEmbedImage{URL: e.ImageURL}
+	}
+
+	if e.Footer.Text != "" {
+		res.Footer = &discordgo.MessageEmbedFooter{Text: e.Footer.Text, IconURL: e.Footer.IconURL}
+	}
+
+	if !e.Timestamp.IsZero() {
+		res.Timestamp = e.Timestamp.Format(time.RFC3339)
+	}
+
+	for _, f := range e.Fields {
+		f.Value = truncateRunes(f.Value, discordLimits.fieldValue)
+
+		res.Fields = append(res.Fields, &discordgo.MessageEmbedField{Name: f.Name, Value: f.Value, Inline: f.Inline})
+	}
+
+	return res
+}
+
+func (e Embed) guilded() guildedgo.ChatEmbed {
+	res := guildedgo.ChatEmbed{
+		Title:       e.Title,
+		Description: e.description(),
+		URL:         e.URL,
+		Color:       e.Color,
+		Author: guildedgo.ChatEmbedAuthor{
+			Name:    e.Author.Name,
+			URL:     e.Author.URL,
+			IconURL: e.Author.IconURL,
+		},
+		Footer: guildedgo.ChatEmbedFooter{
+			Text:    e.Footer.Text,
+			IconURL: e.Footer.IconURL,
+		},
+		Thumbnail: guildedgo.ChatEmbedThumbnail{
+			URL: e.ThumbnailImageURL,
+		},
+		Image: guildedgo.ChatEmbedImage{
+			URL: e.ImageURL,
+		},
+	}
+
+	if !e.Timestamp.IsZero() {
+		res.Timestamp = e.Timestamp.Format(time.RFC3339)
+	}
+
+	for _, f := range e.Fields {
+		res.Fields = append(res.Fields, guildedgo.ChatEmbedField{Name: f.Name, Value: f.Value, Inline: f.Inline})
+	}
+
+	return res
+}
+
+// telegram renders the embed as a section of a Telegram message. Images are
+// not part of the text and are sent as photos instead.
+func (e Embed) telegram() (rt RichText) {
+	// Add Author
+	switch {
+	case e.Author.URL != "" && e.Author.Name != "":
+		rt = append(rt, Link(e.Author.Name, e.Author.URL), Plain("\n"))
+	case e.Author.Name != "":
+		rt = append(rt, Italic(e.Author.Name), Plain("\n"))
+	}
+
+	// Add Title and URL
+	switch {
+	case e.URL != "":
+		rt = append(rt, Link(e.Title, e.URL), Plain("\n"))
+	case e.Title != "":
+		rt = append(rt, Bold(e.Title), Plain("\n"))
+	}
+
+	// Add Description
+	if e.RichDescription != nil {
+		rt = append(rt, e.RichDescription...)
+		rt = append(rt, Plain("\n\n"))
+	} else if e.Description != "" {
+		rt = append(rt, Plain(e.Description+"\n\n"))
+	}
+
+	// Add Fields
+	for _, v := range e.Fields {
+		rt = append(rt, Bold(v.Name), Plain("\n"+v.Value+"\n\n"))
+	}
+
+	// Add Footer and Timestamp
+	footer := e.Footer.Text
+	if !e.Timestamp.IsZero() {
+		if footer != "" {
+			footer += " • "
+		}
+		footer += e.Timestamp.UTC().Format(telegramTimeLayout)
+	}
+
+	if footer != "" {
+		rt = append(rt, Plain(footer+"\n\n"))
+	}
+
+	return rt
+}
+
+// images returns the large images of every embed as attachments
+func (m *Message) images() (res []Attachment) {
+	for _, e := range m.embeds() {
+		if e.ImageURL == "" {
+			continue
+		}
+
+		// Telegram fetches URLs itself, so the exact type does not matter as
+		// long as it is sent as a photo
+		a := Attachment{URL: e.ImageURL}
+		if !a.isPhoto() {
+			a.ContentType = "image/jpeg"
+		}
+
+		res = append(res, a)
+	}
+
+	return res
+}
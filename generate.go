@@ -2,8 +2,11 @@ package crossbot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/go-telegram/bot/models"
@@ -11,118 +14,151 @@ import (
 )
 
 func (m *Message) Discord() *discordgo.InteractionResponseData {
-	return &discordgo.InteractionResponseData{
+	m = m.forPlatform(PlatformDiscord)
+	res := &discordgo.InteractionResponseData{
 		Content: m.Content,
-		Embeds: []*discordgo.MessageEmbed{{
-			URL:         m.URL,
-			Title:       m.Title,
-			Description: m.Description,
-			Color:       m.Color,
-			Thumbnail:   &discordgo.MessageEmbedThumbnail{URL: m.ThumbnailImageURL},
-			Footer:      &discordgo.MessageEmbedFooter{Text: m.Footer.Text, IconURL: m.Footer.IconURL},
-			Fields: func() (res []*discordgo.MessageEmbedField) {
-				for _, f := range m.Fields {
-					if len(f.Value) > 1024 {
-						f.Value = f.Value[:1024]
-					}
-
-					res = append(res, &discordgo.MessageEmbedField{Name: f.Name, Value: f.Value, Inline: f.Inline})
-				}
+		Embeds: func() (res []*discordgo.MessageEmbed) {
+			for _, e := range m.embeds() {
+				res = append(res, e.discord())
+			}
 
-				return res
-			}(),
-		}},
+			return res
+		}(),
 		Components: func() (res []discordgo.MessageComponent) {
 			for _, r := range m.Buttons {
 				var row discordgo.ActionsRow
 				for _, b := range r {
-					id := b.Callback.Register()
-					CallbackCache[id] = b.Callback
+					if !b.visibleOn(PlatformDiscord) {
+						continue
+					}
 
 					button := discordgo.Button{
-						Style:    discordgo.PrimaryButton,
+						Style:    b.Style.discord(),
 						Label:    b.Label,
-						CustomID: id,
-						Emoji:    &discordgo.ComponentEmoji{Name: b.Emoji},
+						Disabled: b.Disabled,
+					}
+
+					if b.Emoji != "" {
+						button.Emoji = &discordgo.ComponentEmoji{Name: b.Emoji}
+					}
+
+					// Link buttons do not send interactions, so no callback is needed
+					if b.URL != "" {
+						button.Style = discordgo.LinkButton
+						button.URL = b.URL
+					} else {
+						id := b.Callback.Register()
+						button.CustomID = id
 					}
+
 					row.Components = append(row.Components, button)
 				}
-				res = append(res, row)
+
+				if len(row.Components) > 0 {
+					res = append(res, row)
+				}
+			}
+
+			for _, sel := range m.Selects {
+				id := sel.Callback.Register()
+
+				menu := discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    id,
+					Placeholder: sel.Placeholder,
+					MaxValues:   sel.MaxValues,
+				}
+
+				if sel.MinValues > 0 {
+					menu.MinValues = &sel.MinValues
+				}
+
+				for _, o := range sel.Options {
+					opt := discordgo.SelectMenuOption{
+						Label:       o.Label,
+						Value:       o.Value,
+						Description: o.Description,
+						Default:     o.Default,
+					}
+
+					if o.Emoji != "" {
+						opt.Emoji = &discordgo.ComponentEmoji{Name: o.Emoji}
+					}
+
+					menu.Options = append(menu.Options, opt)
+				}
+
+				res = append(res, discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}})
 			}
 
 			return res
 		}(),
+		Files: m.discordFiles(),
 	}
+
+	if hook := m.override(PlatformDiscord).Discord; hook != nil {
+		hook(res)
+	}
+
+	return res
 }
 
 func (m *Message) Guilded() *guildedgo.MessageObject {
-	return &guildedgo.MessageObject{
-		Content: m.Content,
-		Embeds: []guildedgo.ChatEmbed{{
-			Title:       m.Title,
-			Description: m.Description,
-			URL:         m.URL,
-			Color:       m.Color,
-			Footer: guildedgo.ChatEmbedFooter{
-				Text:    m.Footer.Text,
-				IconURL: m.Footer.IconURL,
-			},
-			Thumbnail: guildedgo.ChatEmbedThumbnail{
-				URL: m.ThumbnailImageURL,
-			},
-			Fields: func() (res []guildedgo.ChatEmbedField) {
-				for _, f := range m.Fields {
-					res = append(res, guildedgo.ChatEmbedField{Name: f.Name, Value: f.Value, Inline: f.Inline})
-				}
+	m = m.forPlatform(PlatformGuilded)
+	res := &guildedgo.MessageObject{Content: m.Content}
+	for _, e := range m.embeds() {
+		res.Embeds = append(res.Embeds, e.guilded())
+	}
 
-				return res
-			}(),
-		}},
+	if hook := m.override(PlatformGuilded).Guilded; hook != nil {
+		hook(res)
 	}
-}
 
-func (m *Message) Telegram() (text string, markup models.ReplyMarkup) {
-	var sb strings.Builder
+	return res
+}
 
+// telegramText lays out the message's content and embeds as a single text,
+// as Telegram shows it
+func (m *Message) telegramText() (rt RichText) {
 	// Add Content
 	if m.Content != "" {
-		sb.WriteString(m.Content + "\n\n")
-	}
-
-	// Add Title and URL
-	if m.URL != "" {
-		sb.WriteString(fmt.Sprintf("[%s](%s)\n", m.Title, m.URL))
-	} else {
-		sb.WriteString(m.Title + "\n")
+		rt = append(rt, Plain(m.Content+"\n\n"))
 	}
 
-	// Add Description
-	if m.Description != "" {
-		sb.WriteString(m.Description + "\n\n")
+	// Add Embeds
+	for _, e := range m.embeds() {
+		rt = append(rt, e.telegram()...)
 	}
 
-	// Add Fields
-	if len(m.Fields) > 0 {
-		for _, v := range m.Fields {
-			sb.WriteString(fmt.Sprintf("**%s**\n%s\n\n", v.Name, v.Value))
-		}
-	}
+	return rt
+}
 
-	// Add Footer
-	if m.Footer.Text != "" {
-		sb.WriteString(m.Footer.Text)
-	}
+// Telegram renders the message as MarkdownV2 text, which must be sent with
+// the MarkdownV2 parse mode, along with its inline keyboard
+func (m *Message) Telegram() (text string, markup models.ReplyMarkup) {
+	m = m.forPlatform(PlatformTelegram)
 
 	// Remove any unnecessary whitespace
-	text = strings.TrimSpace(sb.String())
+	text = strings.TrimSpace(m.telegramText().TelegramMarkdown())
 
 	// Parse buttons as valid markup
 	kb := models.InlineKeyboardMarkup{}
 	for _, r := range m.Buttons {
 		var row []models.InlineKeyboardButton
 		for _, b := range r {
+			if !b.visibleOn(PlatformTelegram) || b.Disabled {
+				continue
+			}
+
+			if b.URL != "" {
+				row = append(row, models.InlineKeyboardButton{
+					Text: strings.TrimSpace(fmt.Sprintf("%s %s", b.Emoji, b.Label)),
+					URL:  b.URL,
+				})
+				continue
+			}
+
 			id := b.Callback.Register()
-			CallbackCache[id] = b.Callback
 
 			var button models.InlineKeyboardButton
 			if b.Callback.Action != CallbackActionPrompt {
@@ -131,22 +167,18 @@ func (m *Message) Telegram() (text string, markup models.ReplyMarkup) {
 					CallbackData: id,
 				}
 			} else {
-				prompt := fmt.Sprintf("%s\n\n", b.Callback.Prompt.Prefix)
-				for _, p := range b.Callback.Prompt.Fields {
-					key := strings.ReplaceAll(strings.ToLower(p.Key), " ", "_")
-					value := strings.ReplaceAll(strings.ToLower(p.Value), " ", "_")
-					prompt += fmt.Sprintf("--%s=\"%s\"\n", key, value)
-				}
-
 				button = models.InlineKeyboardButton{
 					Text:                         fmt.Sprintf("%s %s", b.Emoji, b.Label),
-					SwitchInlineQueryCurrentChat: prompt,
+					SwitchInlineQueryCurrentChat: promptText(b.Callback.Prompt),
 				}
 			}
 
 			row = append(row, button)
 		}
-		kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+
+		if len(row) > 0 {
+			kb.InlineKeyboard = append(kb.InlineKeyboard, row)
+		}
 	}
 
 	if len(kb.InlineKeyboard) == 0 {
@@ -155,9 +187,41 @@ func (m *Message) Telegram() (text string, markup models.ReplyMarkup) {
 		markup = models.ReplyMarkup(kb)
 	}
 
+	if hook := m.override(PlatformTelegram).Telegram; hook != nil {
+		return hook(text, markup)
+	}
+
 	return text, markup
 }
 
+// Errors returned for callbacks the user may not use
+var (
+	ErrCallbackNotOwner = errors.New("this button belongs to someone else")
+	ErrCallbackExpired  = errors.New("this button has expired")
+)
+
+// allowed checks whether the user with the ID may use the callback
+func (cb Callback) allowed(userID string) error {
+	switch {
+	case cb.OwnerID != "" && cb.OwnerID != userID:
+		return ErrCallbackNotOwner
+	case !cb.Expires.IsZero() && time.Now().After(cb.Expires):
+		return ErrCallbackExpired
+	default:
+		return nil
+	}
+}
+
+// noop reports whether using the callback has no effect
+func (cb Callback) noop() bool {
+	switch cb.Action {
+	case CallbackActionEditMessage, CallbackActionCreateMessage:
+		return cb.Function == nil
+	default:
+		return false
+	}
+}
+
 func (cb Callback) Run(user string, platform Platform) *Message {
 	fields, err := cb.ParseFields(user, platform)
 	if err != nil {
@@ -168,9 +232,11 @@ func (cb Callback) Run(user string, platform Platform) *Message {
 }
 
 func (cb Callback) ParseFields(user string, platform Platform) (map[string]string, error) {
-	var fields map[string]string
-	if err := json.Unmarshal([]byte(cb.Fields), &fields); err != nil {
-		return nil, err
+	fields := make(map[string]string)
+	if cb.Fields != "" {
+		if err := json.Unmarshal([]byte(cb.Fields), &fields); err != nil {
+			return nil, err
+		}
 	}
 
 	fields["user"] = user
@@ -179,8 +245,64 @@ func (cb Callback) ParseFields(user string, platform Platform) (map[string]strin
 	return fields, nil
 }
 
+// visibleOn reports whether the button should be shown on the platform
+func (b Button) visibleOn(p Platform) bool {
+	if len(b.Platforms) == 0 {
+		return true
+	}
+
+	for _, v := range b.Platforms {
+		if v == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s ButtonStyle) discord() discordgo.ButtonStyle {
+	switch s {
+	case ButtonStyleSecondary:
+		return discordgo.SecondaryButton
+	case ButtonStyleSuccess:
+		return discordgo.SuccessButton
+	case ButtonStyleDanger:
+		return discordgo.DangerButton
+	default:
+		return discordgo.PrimaryButton
+	}
+}
+
+// withFields returns a copy of the callback with additional fields
+func (cb Callback) withFields(extra map[string]string) Callback {
+	fields := make(map[string]string)
+	if cb.Fields != "" {
+		if err := json.Unmarshal([]byte(cb.Fields), &fields); err != nil {
+			return cb
+		}
+	}
+
+	for k, v := range extra {
+		fields[k] = v
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return cb
+	}
+
+	cb.Fields = string(data)
+	return cb
+}
+
+// Register stores the callback, returning the ID it can be looked up by
 func (cb Callback) Register() string {
-	index := fmt.Sprint(len(CallbackCache))
-	CallbackCache[index] = cb
-	return index
+	id := callbackPrefix + "." + strconv.FormatUint(callbackID.Add(1), 36)
+
+	callbackCache.Lock()
+	defer callbackCache.Unlock()
+
+	sweepCallbacks(time.Now())
+	CallbackCache[id] = cb
+	return id
 }
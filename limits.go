@@ -0,0 +1,533 @@
+package crossbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// LimitStrategy decides how messages exceeding platform limits are handled
+type LimitStrategy uint8
+
+const (
+	// Truncate oversized parts on rune boundaries, ending them with an ellipsis
+	LimitTruncate LimitStrategy = iota
+
+	// Split the message into several messages, truncating anything that still
+	// does not fit (i.e. a single oversized field value)
+	LimitSplit
+
+	// Refuse to send the message
+	LimitError
+)
+
+// ellipsis is appended to truncated text
+const ellipsis = "…"
+
+// tooLongMessage is shown instead of responses refused by LimitError
+var tooLongMessage = &Message{Title: "The response is too long to be shown"}
+
+// LimitViolation describes a part of a message exceeding a platform limit.
+// Lengths are counted in runes, or in UTF-16 code units on Telegram.
+type LimitViolation struct {
+	Platform Platform
+	Part     string
+	Length   int
+	Limit    int
+}
+
+func (v LimitViolation) Error() string {
+	return fmt.Sprintf("%s is %d characters long, exceeding the limit of %d", v.Part, v.Length, v.Limit)
+}
+
+// platformLimits holds the maximum length of each part of a message. A
+// limit of 0 means unlimited.
+type platformLimits struct {
+	content     int
+	title       int
+	description int
+	fieldName   int
+	fieldValue  int
+	fields      int
+	footer      int
+	authorName  int
+	buttonLabel int
+
+	// Maximum number of embeds, including the message's own
+	embeds int
+
+	// Maximum number of component rows (button rows and select menus)
+	rows int
+
+	// Maximum combined length of the message
+	total int
+
+	// Whether lengths are counted in UTF-16 code units instead of runes, as
+	// characters outside the Basic Multilingual Plane (i.e. emoji) count
+	// twice
+	utf16 bool
+}
+
+var (
+	discordLimits = platformLimits{
+		content:     2000,
+		title:       256,
+		description: 4096,
+		fieldName:   256,
+		fieldValue:  1024,
+		fields:      25,
+		footer:      2048,
+		authorName:  256,
+		buttonLabel: 80,
+		embeds:      10,
+		rows:        5,
+		total:       6000,
+	}
+
+	// Telegram renders everything into a single text. Callback data is
+	// always a short callback cache ID, so its 64 byte limit is never reached.
+	telegramLimits = platformLimits{
+		total: 4096,
+		utf16: true,
+	}
+)
+
+// length returns the length of s as counted by the platform
+func (l platformLimits) length(s string) int {
+	if !l.utf16 {
+		return utf8.RuneCountInString(s)
+	}
+
+	n := 0
+	for _, r := range s {
+		n += l.runeLength(r)
+	}
+
+	return n
+}
+
+// runeLength returns the length of a single rune as counted by the platform
+func (l platformLimits) runeLength(r rune) int {
+	if l.utf16 {
+		return max(utf16.RuneLen(r), 1)
+	}
+
+	return 1
+}
+
+func limitsFor(p Platform) platformLimits {
+	switch p {
+	case PlatformDiscord:
+		return discordLimits
+	case PlatformTelegram:
+		return telegramLimits
+	default:
+		return platformLimits{}
+	}
+}
+
+// Validate reports every part of the message exceeding the platform's
+// limits, after merging the platform's override
+func (m *Message) Validate(p Platform) (violations []LimitViolation) {
+	m = m.forPlatform(p)
+	l := limitsFor(p)
+
+	check := func(part, s string, limit int) {
+		if n := l.length(s); limit > 0 && n > limit {
+			violations = append(violations, LimitViolation{Platform: p, Part: part, Length: n, Limit: limit})
+		}
+	}
+
+	check("content", m.Content, l.content)
+
+	embeds := m.embeds()
+	for i, e := range embeds {
+		prefix := ""
+		if len(embeds) > 1 {
+			prefix = fmt.Sprintf("embed %d ", i+1)
+		}
+
+		check(prefix+"title", e.Title, l.title)
+		check(prefix+"description", e.descriptionText(), l.description)
+		check(prefix+"author name", e.Author.Name, l.authorName)
+		check(prefix+"footer", e.Footer.Text, l.footer)
+
+		for j, f := range e.Fields {
+			check(fmt.Sprintf("%sfield %d name", prefix, j+1), f.Name, l.fieldName)
+			check(fmt.Sprintf("%sfield %d value", prefix, j+1), f.Value, l.fieldValue)
+		}
+
+		if l.fields > 0 && len(e.Fields) > l.fields {
+			violations = append(violations, LimitViolation{Platform: p, Part: prefix + "fields", Length: len(e.Fields), Limit: l.fields})
+		}
+	}
+
+	if l.embeds > 0 && len(embeds) > l.embeds {
+		violations = append(violations, LimitViolation{Platform: p, Part: "embeds", Length: len(embeds), Limit: l.embeds})
+	}
+
+	for i, r := range m.Buttons {
+		for j, b := range r {
+			check(fmt.Sprintf("button %d.%d label", i+1, j+1), b.Label, l.buttonLabel)
+		}
+	}
+
+	if rows := len(m.Buttons) + len(m.Selects); l.rows > 0 && rows > l.rows {
+		violations = append(violations, LimitViolation{Platform: p, Part: "component rows", Length: rows, Limit: l.rows})
+	}
+
+	if n := m.textLength(p); l.total > 0 && n > l.total {
+		violations = append(violations, LimitViolation{Platform: p, Part: "message", Length: n, Limit: l.total})
+	}
+
+	return violations
+}
+
+// fit applies the limit strategy to a message for a platform, returning the
+// messages to send in order
+func (c *Config) fit(m *Message, p Platform) ([]*Message, error) {
+	m = m.forPlatform(p)
+	violations := m.Validate(p)
+	if len(violations) == 0 {
+		return []*Message{m}, nil
+	}
+
+	switch c.LimitStrategy {
+	case LimitSplit:
+		parts := m.split(p)
+		for _, part := range parts {
+			part.Overrides = m.Overrides
+		}
+
+		return parts, nil
+
+	case LimitError:
+		errs := make([]error, len(violations))
+		for i, v := range violations {
+			errs[i] = v
+		}
+		return nil, fmt.Errorf("message exceeds platform limits: %w", errors.Join(errs...))
+
+	default:
+		return []*Message{m.truncate(p)}, nil
+	}
+}
+
+// fitOne is like fit, but truncates instead of splitting, for cases where
+// only a single message can be shown (i.e. edits)
+func (c *Config) fitOne(m *Message, p Platform) (*Message, error) {
+	if c.LimitStrategy == LimitError {
+		parts, err := c.fit(m, p)
+		if err != nil {
+			return nil, err
+		}
+
+		return parts[0], nil
+	}
+
+	m = m.forPlatform(p)
+	if len(m.Validate(p)) == 0 {
+		return m, nil
+	}
+
+	return m.truncate(p), nil
+}
+
+// truncate returns a copy of the message with every part cut down to fit
+func (m *Message) truncate(p Platform) *Message {
+	l := limitsFor(p)
+	res := *m
+
+	res.Content = truncateRunes(m.Content, l.content)
+	res.Title = truncateRunes(m.Title, l.title)
+	res.Author.Name = truncateRunes(m.Author.Name, l.authorName)
+	res.Footer.Text = truncateRunes(m.Footer.Text, l.footer)
+
+	// Formatting is dropped from descriptions that have to be cut
+	if l.description > 0 && utf8.RuneCountInString(m.descriptionText()) > l.description {
+		res.RichDescription = nil
+		res.Description = truncateRunes(m.descriptionText(), l.description)
+	}
+
+	res.Fields = nil
+	for i, f := range m.Fields {
+		if l.fields > 0 && i >= l.fields {
+			break
+		}
+
+		f.Name = truncateRunes(f.Name, l.fieldName)
+		f.Value = truncateRunes(f.Value, l.fieldValue)
+		res.Fields = append(res.Fields, f)
+	}
+
+	// Extra embeds are cut down the same way, dropping any beyond the limit
+	res.Embeds = nil
+	for _, e := range m.Embeds {
+		if l.embeds > 0 && len(res.embeds()) >= l.embeds {
+			break
+		}
+
+		res.Embeds = append(res.Embeds, e.truncate(l))
+	}
+
+	res.Buttons, res.Selects = nil, m.Selects
+	for i, r := range m.Buttons {
+		if l.rows > 0 && i >= l.rows {
+			break
+		}
+
+		row := make([]Button, len(r))
+		for j, b := range r {
+			b.Label = truncateRunes(b.Label, l.buttonLabel)
+			row[j] = b
+		}
+		res.Buttons = append(res.Buttons, row)
+	}
+
+	if l.rows > 0 && len(res.Buttons)+len(res.Selects) > l.rows {
+		res.Selects = res.Selects[:l.rows-len(res.Buttons)]
+	}
+
+	// Drop extra embeds and fields, then shorten the description until the
+	// whole message fits
+	for l.total > 0 && res.textLength(p) > l.total {
+		if len(res.Embeds) > 0 {
+			res.Embeds = res.Embeds[:len(res.Embeds)-1]
+			continue
+		}
+
+		if len(res.Fields) > 0 {
+			res.Fields = res.Fields[:len(res.Fields)-1]
+			continue
+		}
+
+		if res.RichDescription != nil {
+			res.Description, res.RichDescription = res.RichDescription.String(), nil
+		}
+
+		excess := res.textLength(p) - l.total
+		n := utf8.RuneCountInString(res.Description) - excess
+		if n <= 0 {
+			res.Description = ""
+			res.Content = truncateRunes(res.Content, max(utf8.RuneCountInString(res.Content)-excess, 1))
+			break
+		}
+
+		res.Description = truncateRunes(res.Description, n)
+	}
+
+	return &res
+}
+
+// truncate returns a copy of the embed with every part cut down to fit
+func (e Embed) truncate(l platformLimits) Embed {
+	e.Title = truncateRunes(e.Title, l.title)
+	e.Author.Name = truncateRunes(e.Author.Name, l.authorName)
+	e.Footer.Text = truncateRunes(e.Footer.Text, l.footer)
+
+	if l.description > 0 && utf8.RuneCountInString(e.descriptionText()) > l.description {
+		e.Description, e.RichDescription = truncateRunes(e.descriptionText(), l.description), nil
+	}
+
+	fields := e.Fields
+	if l.fields > 0 && len(fields) > l.fields {
+		fields = fields[:l.fields]
+	}
+
+	e.Fields = nil
+	for _, f := range fields {
+		f.Name = truncateRunes(f.Name, l.fieldName)
+		f.Value = truncateRunes(f.Value, l.fieldValue)
+		e.Fields = append(e.Fields, f)
+	}
+
+	return e
+}
+
+// split divides the message into several messages that each fit. The title,
+// author and content stay on the first message, while the footer, image,
+// extra embeds, buttons, menus and attachments move to the last one.
+// Formatting is dropped from split descriptions.
+func (m *Message) split(p Platform) []*Message {
+	l := limitsFor(p)
+
+	descLimit := l.description
+	if descLimit == 0 || (l.total > 0 && l.total < descLimit) {
+		descLimit = l.total
+	}
+
+	first := &Message{
+		Content:           m.Content,
+		Title:             m.Title,
+		URL:               m.URL,
+		Color:             m.Color,
+		Author:            m.Author,
+		ThumbnailImageURL: m.ThumbnailImageURL,
+	}
+
+	// Leave room for the title and content on the first message
+	if l.total > 0 {
+		room := l.total - first.textLength(p) - 8
+		if room > 0 && room < descLimit {
+			descLimit = room
+		}
+	}
+
+	msgs := []*Message{first}
+	chunks := splitText(m.descriptionText(), descLimit, l.runeLength)
+	if len(chunks) == 1 {
+		first.Description, first.RichDescription = m.Description, m.RichDescription
+		chunks = nil
+	}
+
+	for i, chunk := range chunks {
+		if i == 0 {
+			first.Description = chunk
+			continue
+		}
+
+		msgs = append(msgs, &Message{Color: m.Color, Description: chunk})
+	}
+
+	for _, f := range m.Fields {
+		f.Name = truncateRunes(f.Name, l.fieldName)
+		f.Value = truncateRunes(f.Value, l.fieldValue)
+
+		cur := msgs[len(msgs)-1]
+		next := *cur
+		next.Fields = append(append([]Field(nil), cur.Fields...), f)
+
+		full := l.fields > 0 && len(cur.Fields) >= l.fields
+		if full || (l.total > 0 && next.textLength(p) > l.total) {
+			msgs = append(msgs, &Message{Color: m.Color, Fields: []Field{f}})
+			continue
+		}
+
+		cur.Fields = next.Fields
+	}
+
+	last := msgs[len(msgs)-1]
+	last.Footer = m.Footer
+	last.Timestamp = m.Timestamp
+	last.ImageURL = m.ImageURL
+	last.Embeds = m.Embeds
+	last.Buttons = m.Buttons
+	last.Selects = m.Selects
+	last.Attachments = m.Attachments
+
+	for i, msg := range msgs {
+		msgs[i] = msg.truncate(p)
+	}
+
+	return msgs
+}
+
+// descriptionText returns the description without formatting
+func (m *Message) descriptionText() string {
+	if m.RichDescription != nil {
+		return m.RichDescription.String()
+	}
+
+	return m.Description
+}
+
+// richDescription returns the description as rich text
+func (m *Message) richDescription() RichText {
+	if m.RichDescription != nil || m.Description == "" {
+		return m.RichDescription
+	}
+
+	return RichText{Plain(m.Description)}
+}
+
+// textLength returns the length of the message's text counting towards the
+// platform's total limit. Telegram counts the text as laid out, including the
+// lines and labels between the parts, but not the formatting.
+func (m *Message) textLength(p Platform) int {
+	if p == PlatformTelegram {
+		return limitsFor(p).length(strings.TrimSpace(m.telegramText().String()))
+	}
+
+	return limitsFor(p).length(m.plainText())
+}
+
+// plainText returns every part of the message's text that counts towards
+// Discord's total length
+func (m *Message) plainText() string {
+	parts := []string{m.Content}
+	for _, e := range m.embeds() {
+		parts = append(parts, e.Title, e.Author.Name, e.descriptionText())
+		for _, f := range e.Fields {
+			parts = append(parts, f.Name, f.Value)
+		}
+		parts = append(parts, e.Footer.Text)
+	}
+
+	return strings.Join(parts, "")
+}
+
+// truncateRunes cuts s down to at most limit runes, ending it with an
+// ellipsis if anything was removed. A limit of 0 means unlimited.
+func truncateRunes(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:limit-1]) + ellipsis
+}
+
+// splitRunes splits s into chunks of at most limit runes, preferring to break
+// on newlines, then spaces
+func splitRunes(s string, limit int) []string {
+	return splitText(s, limit, func(rune) int { return 1 })
+}
+
+// splitText splits s into chunks at most limit long, measuring each rune with
+// size, preferring to break on newlines, then spaces
+func splitText(s string, limit int, size func(rune) int) []string {
+	runes := []rune(s)
+
+	// fitting returns how many of the runes fit within the limit
+	fitting := func() int {
+		n := 0
+		for i, r := range runes {
+			if n += size(r); n > limit {
+				return max(i, 1)
+			}
+		}
+
+		return len(runes)
+	}
+
+	if limit <= 0 || fitting() == len(runes) {
+		return []string{s}
+	}
+
+	var chunks []string
+	for end := fitting(); end < len(runes); end = fitting() {
+		cut := end
+		if i := lastIndexRune(runes[:end], '\n'); i > end/2 {
+			cut = i + 1
+		} else if i := lastIndexRune(runes[:end], ' '); i > end/2 {
+			cut = i + 1
+		}
+
+		chunks = append(chunks, strings.TrimSpace(string(runes[:cut])))
+		runes = runes[cut:]
+	}
+
+	return append(chunks, strings.TrimSpace(string(runes)))
+}
+
+func lastIndexRune(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,238 @@
+package crossbot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit int
+		want  string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 4, "hel…"},
+		{"hello", 1, "…"},
+		{"héllo wörld", 11, "héllo wörld"},
+		{"héllo wörld", 10, "héllo wör…"},
+		{"🙂🙂🙂", 3, "🙂🙂🙂"},
+		{"🙂🙂🙂", 2, "🙂…"},
+		{"", 3, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncateRunes(tt.s, tt.limit); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRunes(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit int
+		want  []string
+	}{
+		{"hello world", 0, []string{"hello world"}},
+		{"hello world", 11, []string{"hello world"}},
+		{"hello world", 9, []string{"hello", "world"}},
+		{"hello world", 10, []string{"hello worl", "d"}},
+		{"abcdefghij", 5, []string{"abcde", "fghij"}},
+		{"abcdefghijk", 5, []string{"abcde", "fghij", "k"}},
+		{"one two\nthree four", 12, []string{"one two", "three four"}},
+		{"ab cdefghij", 6, []string{"ab cde", "fghij"}},
+		{"éééééé", 3, []string{"ééé", "ééé"}},
+		{"🙂🙂 🙂🙂", 3, []string{"🙂🙂", "🙂🙂"}},
+	}
+
+	for _, tt := range tests {
+		got := splitRunes(tt.s, tt.limit)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitRunes(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+		}
+
+		for _, chunk := range got {
+			if n := utf8.RuneCountInString(chunk); tt.limit > 0 && n > tt.limit {
+				t.Errorf("splitRunes(%q, %d) returned a chunk of %d runes", tt.s, tt.limit, n)
+			}
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		msg      *Message
+	}{
+		{
+			name:     "discord description at limit",
+			platform: PlatformDiscord,
+			msg:      &Message{Description: strings.Repeat("a", discordLimits.description)},
+		},
+		{
+			name:     "discord description over limit",
+			platform: PlatformDiscord,
+			msg:      &Message{Description: strings.Repeat("a", discordLimits.description+1)},
+		},
+		{
+			name:     "discord total over limit",
+			platform: PlatformDiscord,
+			msg: &Message{
+				Content:     strings.Repeat("c", discordLimits.content),
+				Title:       strings.Repeat("t", discordLimits.title),
+				Description: strings.Repeat("d", discordLimits.description),
+			},
+		},
+		{
+			name:     "telegram text at limit",
+			platform: PlatformTelegram,
+			msg:      &Message{Title: "T", Description: strings.Repeat("a", telegramLimits.total-2)},
+		},
+		{
+			name:     "telegram layout over limit",
+			platform: PlatformTelegram,
+			msg:      &Message{Title: "T", Description: strings.Repeat("a", telegramLimits.total-1)},
+		},
+		{
+			name:     "telegram fields over limit",
+			platform: PlatformTelegram,
+			msg: &Message{
+				Title:       "Title",
+				Description: strings.Repeat("a", 4000),
+				Fields:      []Field{{Name: "Name", Value: strings.Repeat("v", 80)}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.msg.truncate(tt.platform)
+		if v := got.Validate(tt.platform); len(v) > 0 {
+			t.Errorf("%s: truncated message still violates limits: %v", tt.name, v)
+		}
+
+		if len(tt.msg.Validate(tt.platform)) == 0 && got.descriptionText() != tt.msg.descriptionText() {
+			t.Errorf("%s: message within limits was changed", tt.name)
+		}
+	}
+}
+
+func TestTelegramLimitCountsLayout(t *testing.T) {
+	// The title and the line after it are shown, so they count towards the limit
+	msg := &Message{Title: "T", Description: strings.Repeat("a", telegramLimits.total-1)}
+	if len(msg.Validate(PlatformTelegram)) == 0 {
+		t.Error("message longer than the limit once laid out passed validation")
+	}
+
+	// Formatting is not counted
+	msg = &Message{RichDescription: RichText{Bold(strings.Repeat("a", telegramLimits.total))}}
+	if v := msg.Validate(PlatformTelegram); len(v) > 0 {
+		t.Errorf("formatting counted towards the limit: %v", v)
+	}
+}
+
+func TestTelegramLimitCountsUTF16(t *testing.T) {
+	// Emoji outside the Basic Multilingual Plane count twice
+	msg := &Message{Description: strings.Repeat("🙂", telegramLimits.total/2+1)}
+	if len(msg.Validate(PlatformTelegram)) == 0 {
+		t.Error("message longer than the limit in UTF-16 code units passed validation")
+	}
+
+	if v := msg.truncate(PlatformTelegram).Validate(PlatformTelegram); len(v) > 0 {
+		t.Errorf("truncated message still violates limits: %v", v)
+	}
+
+	parts := msg.split(PlatformTelegram)
+	if len(parts) != 2 {
+		t.Errorf("split into %d parts, want 2", len(parts))
+	}
+
+	var n int
+	for i, part := range parts {
+		if v := part.Validate(PlatformTelegram); len(v) > 0 {
+			t.Errorf("part %d violates limits: %v", i+1, v)
+		}
+		n += utf8.RuneCountInString(part.Description)
+	}
+
+	if want := telegramLimits.total/2 + 1; n != want {
+		t.Errorf("split parts hold %d emoji, want %d", n, want)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		msg      *Message
+		parts    int
+	}{
+		{
+			name:     "discord description",
+			platform: PlatformDiscord,
+			msg:      &Message{Title: "Title", Description: strings.Repeat("word ", 2000)},
+			parts:    3,
+		},
+		{
+			name:     "discord fields",
+			platform: PlatformDiscord,
+			msg: &Message{Fields: func() (fields []Field) {
+				for range discordLimits.fields + 1 {
+					fields = append(fields, Field{Name: "Name", Value: "Value"})
+				}
+				return fields
+			}()},
+			parts: 2,
+		},
+		{
+			name:     "telegram at limit",
+			platform: PlatformTelegram,
+			msg:      &Message{Title: "T", Description: strings.Repeat("a", telegramLimits.total-2)},
+			parts:    1,
+		},
+		{
+			name:     "telegram over limit",
+			platform: PlatformTelegram,
+			msg:      &Message{Title: "T", Description: strings.Repeat("a", telegramLimits.total-1)},
+			parts:    2,
+		},
+		{
+			name:     "telegram description and fields",
+			platform: PlatformTelegram,
+			msg: &Message{
+				Title:       "Title",
+				Description: strings.Repeat("line of text\n", 400),
+				Fields:      []Field{{Name: "Name", Value: strings.Repeat("v", 1000)}},
+				Footer:      Footer{Text: "Footer"},
+			},
+			parts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		parts := tt.msg.split(tt.platform)
+		if len(tt.msg.Validate(tt.platform)) == 0 {
+			parts = []*Message{tt.msg}
+		}
+
+		if len(parts) != tt.parts {
+			t.Errorf("%s: split into %d parts, want %d", tt.name, len(parts), tt.parts)
+		}
+
+		for i, part := range parts {
+			if v := part.Validate(tt.platform); len(v) > 0 {
+				t.Errorf("%s: part %d violates limits: %v", tt.name, i+1, v)
+			}
+		}
+
+		if parts[0].Title != tt.msg.Title {
+			t.Errorf("%s: title moved off the first part", tt.name)
+		}
+		if parts[len(parts)-1].Footer != tt.msg.Footer {
+			t.Errorf("%s: footer moved off the last part", tt.name)
+		}
+	}
+}
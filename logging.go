@@ -0,0 +1,142 @@
+package crossbot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Outcomes of requests, as logged
+const (
+	outcomeOK       = "ok"
+	outcomePanic    = "panic"
+	outcomeTimeout  = "timeout"
+	outcomeCanceled = "canceled"
+)
+
+// LogPrivacy keeps personal data out of the logs
+type LogPrivacy struct {
+	// Log the length of messages instead of their content
+	RedactContent bool
+
+	// Log and trace a hash of user IDs instead of the IDs, and leave out
+	// usernames. Chat IDs are hashed too, as private chats share the user's ID.
+	// Requires HashKey.
+	HashUserIDs bool
+
+	// Secret key of the HMAC user IDs are hashed with. Hashes stay stable as
+	// long as the key does, and cannot be matched to known IDs without it.
+	HashKey []byte
+}
+
+// logger returns the config's logger, falling back to the default one
+func (c *Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+
+	return slog.Default()
+}
+
+// platformName returns the platform's name for logs
+func platformName(p Platform) string {
+	switch p {
+	case PlatformDiscord:
+		return "discord"
+	case PlatformTelegram:
+		return "telegram"
+	case PlatformGuilded:
+		return "guilded"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// targetAttrs describes the chat for logs, respecting the privacy options
+func (c *Config) targetAttrs(t Target) []any {
+	attrs := []any{slog.String("platform", platformName(t.Platform)), slog.String("chat", c.chatID(t))}
+	if t.ThreadID != "" {
+		attrs = append(attrs, slog.String("thread", t.ThreadID))
+	}
+
+	return attrs
+}
+
+// userAttrs describes the user for logs, respecting the privacy options
+func (c *Config) userAttrs(p Platform, user, userID string) []any {
+	if c.LogPrivacy.HashUserIDs {
+		if userID == "" {
+			return nil
+		}
+
+		return []any{slog.String("user_id", c.hashID(p, userID))}
+	}
+
+	var attrs []any
+	if userID != "" {
+		attrs = append(attrs, slog.String("user_id", userID))
+	}
+	if user != "" {
+		attrs = append(attrs, slog.String("user", user))
+	}
+
+	return attrs
+}
+
+// chatID returns the chat's ID for logs and traces, respecting the privacy
+// options
+func (c *Config) chatID(t Target) string {
+	if c.LogPrivacy.HashUserIDs {
+		return c.hashID(t.Platform, t.ChatID)
+	}
+
+	return t.ChatID
+}
+
+// hashID returns the ID's HMAC keyed with LogPrivacy.HashKey
+func (c *Config) hashID(p Platform, id string) string {
+	mac := hmac.New(sha256.New, c.LogPrivacy.HashKey)
+	mac.Write([]byte(platformName(p) + ":" + id))
+
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// contentAttr describes a message's content for logs, respecting the privacy
+// options
+func (c *Config) contentAttr(content string) slog.Attr {
+	if c.LogPrivacy.RedactContent {
+		return slog.Int("content_length", len(content))
+	}
+
+	return slog.String("content", content)
+}
+
+// logMessage logs a message received by the bot. Messages are logged at debug
+// level, as most are not addressed to the bot.
+func (c *Config) logMessage(target Target, user, userID, content string) {
+	attrs := append(c.targetAttrs(target), c.userAttrs(target.Platform, user, userID)...)
+	attrs = append(attrs, c.contentAttr(content))
+
+	c.logger().Debug("Received message", attrs...)
+}
+
+// logRequest logs the outcome of a command or callback
+func (c *Config) logRequest(req *Request, start time.Time, outcome string) {
+	attrs := append(c.targetAttrs(req.Target), c.userAttrs(req.Target.Platform, req.User, req.UserID)...)
+	attrs = append(attrs,
+		slog.String("command", req.Command),
+		slog.Bool("callback", req.Callback),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("outcome", outcome),
+	)
+
+	level := slog.LevelInfo
+	if outcome != outcomeOK {
+		level = slog.LevelWarn
+	}
+
+	c.logger().Log(req.Context(), level, "Handled request", attrs...)
+}
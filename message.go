@@ -1,15 +1,60 @@
 package crossbot
 
+import "time"
+
 type Message struct {
 	Content           string
 	Title             string
 	Description       string
+	RichDescription   RichText // Formatted description used instead of Description
 	URL               string
 	Color             int
+	Author            Author
 	ThumbnailImageURL string
+	ImageURL          string
 	Footer            Footer
+	Timestamp         time.Time
 	Fields            []Field
 	Buttons           [][]Button
+	Selects           []Select
+	Attachments       []Attachment
+
+	// Additional embeds shown after the message's own
+	Embeds []Embed
+
+	// Parts of the message replaced on specific platforms
+	Overrides map[Platform]*Override
+
+	// Who can see the message when sent as a command response. Overrides the
+	// command's visibility unless public.
+	Visibility Visibility
+
+	// Set on responses only telling the user a request failed, such as
+	// callbacks turned away by the worker pools, panics and timeouts.
+	// Callbacks show them to the user alone and leave their message alone.
+	notice bool
+}
+
+// Embed is a single embed of a message. On Telegram each embed becomes its
+// own section of the text, with images sent as photos.
+type Embed struct {
+	Title             string
+	Description       string
+	RichDescription   RichText // Formatted description used instead of Description
+	URL               string
+	Color             int
+	Author            Author
+	ThumbnailImageURL string
+	ImageURL          string
+	Footer            Footer
+	Timestamp         time.Time
+	Fields            []Field
+}
+
+type Author struct {
+	Name    string
+	URL     string
+	IconURL string
 }
 
 type Footer struct {
@@ -27,6 +72,51 @@ type Button struct {
 	Label    string
 	Emoji    string
 	Callback Callback
+
+	// Opens the URL instead of running the callback
+	URL string
+
+	// Discord button style. Link buttons always use the link style.
+	Style ButtonStyle
+
+	// Disabled buttons are greyed out on Discord and hidden on Telegram, which
+	// does not support disabling buttons
+	Disabled bool
+
+	// Platforms to show the button on. Shown on all platforms if unspecified.
+	Platforms []Platform
+}
+
+type ButtonStyle uint8
+
+const (
+	ButtonStylePrimary ButtonStyle = iota
+	ButtonStyleSecondary
+	ButtonStyleSuccess
+	ButtonStyleDanger
+)
+
+// Select is a menu of options. On Discord it is rendered as a string select
+// menu, while Telegram falls back to an inline keyboard with one button per
+// option, only allowing a single choice. The chosen values are provided to
+// the callback as a comma separated list under the "values" field.
+type Select struct {
+	Placeholder string
+
+	// Minimum and maximum number of options that can be chosen. Both default to 1.
+	MinValues int
+	MaxValues int
+
+	Options  []SelectOption
+	Callback Callback
+}
+
+type SelectOption struct {
+	Label       string
+	Value       string
+	Description string
+	Emoji       string
+	Default     bool
 }
 
 type Callback struct {
@@ -35,6 +125,22 @@ type Callback struct {
 	Function     func(map[string]string) *Message
 	Prompt       Prompt
 	AlertMessage func(map[string]string) string
+
+	// Platform user ID of the only user allowed to use the callback. Anyone
+	// may if empty.
+	OwnerID string
+
+	// Time after which the callback stops working. Never expires if zero.
+	Expires time.Time
+
+	// Command whose response created the callback, whose middlewares it runs
+	// through
+	origin *Command
+
+	// Set on callbacks continuing the command's invocation, such as page
+	// turns and conversation answers, which are not charged to its rate
+	// limits again
+	continuation bool
 }
 
 type Prompt struct {
@@ -0,0 +1,133 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// handlerDurationBuckets are the upper bounds of the handler duration
+// histogram, in seconds
+var handlerDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics counts what the bot does, registered on a registry of its own so
+// several configs can run in one process
+type metrics struct {
+	once     sync.Once
+	registry *prometheus.Registry
+
+	invocations  *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	callbacks    *prometheus.CounterVec
+	cacheHits    *prometheus.CounterVec
+	cacheMisses  *prometheus.CounterVec
+	sendFailures *prometheus.CounterVec
+	rateLimited  *prometheus.CounterVec
+}
+
+// metric returns the config's metrics, creating them on first use
+func (c *Config) metric() *metrics {
+	m := &c.metrics
+	m.once.Do(func() {
+		m.registry = prometheus.NewRegistry()
+		factory := promauto.With(m.registry)
+
+		m.invocations = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_invocations_total",
+			Help: "Commands and callbacks handled.",
+		}, []string{"platform", "command"})
+		m.errors = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_errors_total",
+			Help: "Handlers that panicked or did not finish.",
+		}, []string{"platform", "command", "outcome"})
+		m.duration = factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "crossbot_handler_duration_seconds",
+			Help:    "Time taken to handle commands and callbacks.",
+			Buckets: handlerDurationBuckets,
+		}, []string{"platform", "command"})
+		m.callbacks = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_callback_presses_total",
+			Help: "Buttons pressed and options selected.",
+		}, []string{"platform", "command"})
+		m.cacheHits = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_cache_hits_total",
+			Help: "Cache lookups that found an entry.",
+		}, []string{"cache"})
+		m.cacheMisses = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_cache_misses_total",
+			Help: "Cache lookups that found no entry.",
+		}, []string{"cache"})
+		m.sendFailures = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_send_failures_total",
+			Help: "Messages that could not be sent, edited or deleted.",
+		}, []string{"platform"})
+		m.rateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "crossbot_rate_limit_hits_total",
+			Help: "Requests refused by rate limits.",
+		}, []string{"platform", "command"})
+	})
+
+	return m
+}
+
+// MetricsHandler serves the bot's metrics in the Prometheus text format, for
+// mounting on an existing HTTP server
+func (c *Config) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(c.metric().registry, promhttp.HandlerOpts{})
+}
+
+// metricsShutdownTimeout is how long in-flight scrapes get to finish once the
+// bot stops
+const metricsShutdownTimeout = 5 * time.Second
+
+// serveMetrics serves the metrics on the configured address until the bot
+// stops or the server fails
+func (c *Config) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.MetricsHandler())
+
+	srv := &http.Server{Addr: c.MetricsAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	// Free the port once the bot stops so it can be started again
+	stop := context.AfterFunc(c.baseContext(), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			c.logger().Error("Failed to shut down metrics server", "error", err)
+		}
+	})
+	defer stop()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.logger().Error("Failed to serve metrics", "address", c.MetricsAddress, "error", err)
+	}
+}
+
+// recordRequest counts a handled command or callback
+func (c *Config) recordRequest(req *Request, start time.Time, outcome string) {
+	m := c.metric()
+	platform := platformName(req.Target.Platform)
+
+	m.invocations.WithLabelValues(platform, req.Command).Inc()
+	m.duration.WithLabelValues(platform, req.Command).Observe(time.Since(start).Seconds())
+	if outcome != outcomeOK {
+		m.errors.WithLabelValues(platform, req.Command, outcome).Inc()
+	}
+}
+
+// recordCache counts a cache lookup
+func (c *Config) recordCache(cache string, hit bool) {
+	if hit {
+		c.metric().cacheHits.WithLabelValues(cache).Inc()
+	} else {
+		c.metric().cacheMisses.WithLabelValues(cache).Inc()
+	}
+}
@@ -0,0 +1,111 @@
+package crossbot
+
+import "slices"
+
+// Middleware wraps a handler to run code around it (i.e. logging, auth or
+// rewriting fields). It may return a message without calling next to stop the
+// command from running.
+type Middleware func(next Handler) Handler
+
+// Group applies the middlewares to every command in the group, running before
+// each command's own middlewares. Groups may be nested.
+func Group(middlewares []Middleware, cmds ...*Command) []*Command {
+	for _, cmd := range cmds {
+		cmd.Middlewares = append(slices.Clone(middlewares), cmd.Middlewares...)
+	}
+
+	return cmds
+}
+
+// chain wraps the handler in the config's middlewares, followed by the
+// command's access rules, rate limits, concurrency limits and middlewares if
+// known. The middlewares and handler each run in a span.
+func (c *Config) chain(cmd *Command, h Handler) Handler {
+	mws := slices.Clone(c.Middlewares)
+	if cmd != nil {
+		if cmd.Access != nil {
+			mws = append(mws, c.accessMiddleware(cmd.Access))
+		}
+		if len(c.RateLimits) > 0 || len(cmd.RateLimits) > 0 {
+			mws = append(mws, c.rateLimitMiddleware(cmd))
+		}
+	}
+
+	if c.Workers > 0 || (cmd != nil && cmd.Concurrency > 0) {
+		mws = append(mws, c.concurrencyMiddleware(cmd))
+	}
+
+	if cmd != nil {
+		mws = append(mws, cmd.Middlewares...)
+	}
+
+	h = c.traced("handler", h)
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return c.traced("middleware", h)
+}
+
+// setOrigin records the command on the message's callbacks that have none
+// yet, so they run through the same middlewares
+func (m *Message) setOrigin(cmd *Command) {
+	if m == nil {
+		return
+	}
+
+	for i := range m.Buttons {
+		for j := range m.Buttons[i] {
+			if m.Buttons[i][j].Callback.origin == nil {
+				m.Buttons[i][j].Callback.origin = cmd
+			}
+		}
+	}
+
+	for i := range m.Selects {
+		if m.Selects[i].Callback.origin == nil {
+			m.Selects[i].Callback.origin = cmd
+		}
+	}
+}
+
+// runCallback runs the callback's function through the middlewares of the
+// config and the command that created it, recovering from panics and
+// enforcing its timeout. Alerts and prompts are shown as messages in place of
+// the function.
+func (c *Config) runCallback(cb Callback, req *Request) *Message {
+	fn := cb.Function
+	switch {
+	case cb.Action == CallbackActionAlert && cb.AlertMessage != nil:
+		fn = func(fields map[string]string) *Message { return &Message{Title: cb.AlertMessage(fields)} }
+	case cb.Action == CallbackActionPrompt:
+		fn = func(map[string]string) *Message { return promptMessage(cb.Prompt) }
+	case fn == nil:
+		return nil
+	}
+
+	fields, err := cb.ParseFields(req.User, req.Target.Platform)
+	if err != nil {
+		return &Message{Title: err.Error()}
+	}
+
+	for k, v := range fields {
+		if _, ok := req.Fields[k]; !ok {
+			req.Fields[k] = v
+		}
+	}
+	req.Callback = true
+
+	if cb.origin != nil {
+		req.Command = cb.origin.name()
+	}
+
+	h := c.guard(cb.origin, c.chain(cb.origin, func(req *Request) *Message {
+		return fn(req.Fields)
+	}))
+
+	msg := h(req)
+	msg.setOrigin(cb.origin)
+
+	return msg
+}
@@ -0,0 +1,181 @@
+package crossbot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordMiddleware appends the name to the log when the request passes
+// through, stopping it instead if stop is set
+func recordMiddleware(log *[]string, name string, stop bool) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) *Message {
+			*log = append(*log, name)
+			if stop {
+				return &Message{Title: "stopped by " + name}
+			}
+
+			return next(req)
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		stopAt string
+		access *Access
+		want   []string
+		title  string
+	}{
+		{"all", "", nil, []string{"config", "group", "command", "handler"}, "handled"},
+		{"config stops", "config", nil, []string{"config"}, "stopped by config"},
+		{"group stops", "group", nil, []string{"config", "group"}, "stopped by group"},
+		{"command stops", "command", nil, []string{"config", "group", "command"}, "stopped by command"},
+		{"access denies", "", &Access{OwnerOnly: true}, []string{"config"}, "🚫 You can't use this command"},
+	}
+
+	for _, tt := range tests {
+		var log []string
+		c := &Config{Middlewares: []Middleware{recordMiddleware(&log, "config", tt.stopAt == "config")}}
+
+		cmd := &Command{
+			Text:        TextCommand{Aliases: []string{"test"}},
+			Access:      tt.access,
+			Middlewares: []Middleware{recordMiddleware(&log, "command", tt.stopAt == "command")},
+		}
+		Group([]Middleware{recordMiddleware(&log, "group", tt.stopAt == "group")}, cmd)
+
+		h := c.chain(cmd, func(*Request) *Message {
+			log = append(log, "handler")
+			return &Message{Title: "handled"}
+		})
+
+		msg := h(newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "-100"}, "user", "1"))
+		if strings.Join(log, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: ran %v, want %v", tt.name, log, tt.want)
+		}
+
+		if msg.Title != tt.title {
+			t.Errorf("%s: responded %q, want %q", tt.name, msg.Title, tt.title)
+		}
+	}
+}
+
+func TestGroupNesting(t *testing.T) {
+	var log []string
+	cmd := &Command{Middlewares: []Middleware{recordMiddleware(&log, "command", false)}}
+
+	Group([]Middleware{recordMiddleware(&log, "outer", false)},
+		Group([]Middleware{recordMiddleware(&log, "inner", false)}, cmd)...)
+
+	h := (&Config{}).chain(cmd, func(*Request) *Message { return nil })
+	h(newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "1"}, "user", "1"))
+
+	if got := strings.Join(log, ","); got != "outer,inner,command" {
+		t.Errorf("nested groups ran %s", got)
+	}
+}
+
+func TestRunCallbackMiddlewares(t *testing.T) {
+	var log []string
+	cmd := &Command{
+		Text:        TextCommand{Aliases: []string{"test"}},
+		Middlewares: []Middleware{recordMiddleware(&log, "command", false)},
+	}
+
+	var fields map[string]string
+	msg := &Message{Buttons: [][]Button{{{Callback: Callback{
+		Fields: `{"page":"2"}`,
+		Function: func(f map[string]string) *Message {
+			fields = f
+			return &Message{Title: "pressed"}
+		},
+	}}}}}
+	msg.setOrigin(cmd)
+
+	c := &Config{Middlewares: []Middleware{recordMiddleware(&log, "config", false)}}
+	req := newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "1"}, "user", "1")
+
+	got := c.runCallback(msg.Buttons[0][0].Callback, req)
+	if got == nil || got.Title != "pressed" {
+		t.Fatalf("callback responded %v", got)
+	}
+
+	if strings.Join(log, ",") != "config,command" {
+		t.Errorf("callback ran %v, want the config's and command's middlewares", log)
+	}
+
+	if fields["page"] != "2" || fields["user_id"] != "1" {
+		t.Errorf("callback got fields %v", fields)
+	}
+
+	if !req.Callback || req.Command != "test" {
+		t.Errorf("callback request is callback = %t for %q", req.Callback, req.Command)
+	}
+}
+
+func TestRunCallbackActions(t *testing.T) {
+	tests := []struct {
+		name  string
+		cb    Callback
+		title string
+	}{
+		{"nothing to run", Callback{}, ""},
+		{"alert", Callback{Action: CallbackActionAlert, AlertMessage: func(map[string]string) string { return "careful" }}, "careful"},
+		{"prompt", Callback{Action: CallbackActionPrompt, Prompt: Prompt{Prefix: "/remind"}}, "Reply with"},
+		{"function", Callback{Function: func(map[string]string) *Message { return &Message{Title: "ran"} }}, "ran"},
+		{"bad fields", Callback{Fields: "{", Function: func(map[string]string) *Message { return nil }}, "unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		msg := c.runCallback(tt.cb, newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "1"}, "user", "1"))
+
+		var title string
+		if msg != nil {
+			title = msg.Title
+		}
+
+		if title != tt.title {
+			t.Errorf("%s: responded %q, want %q", tt.name, title, tt.title)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		handler Handler
+		notice  bool
+		title   string
+	}{
+		{"ok", 0, func(*Request) *Message { return &Message{Title: "ok"} }, false, "ok"},
+		{"panic", 0, func(*Request) *Message { panic("boom") }, true, ""},
+		{"timeout", 10 * time.Millisecond, func(req *Request) *Message {
+			<-req.Context().Done()
+			return &Message{Title: "late"}
+		}, true, ""},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		cmd := &Command{Timeout: tt.timeout}
+
+		msg := c.guard(cmd, tt.handler)(newRequest(context.Background(), Target{Platform: PlatformTelegram, ChatID: "1"}, "user", "1"))
+		if msg == nil {
+			t.Fatalf("%s: no response", tt.name)
+		}
+
+		if msg.notice != tt.notice {
+			t.Errorf("%s: notice = %t, want %t", tt.name, msg.notice, tt.notice)
+		}
+
+		if tt.title != "" && msg.Title != tt.title {
+			t.Errorf("%s: responded %q, want %q", tt.name, msg.Title, tt.title)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package crossbot
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot/models"
+	"github.com/itschip/guildedgo"
+)
+
+// Override replaces parts of a message on a single platform (i.e. a shorter
+// text on Telegram). Unset parts keep the message's own.
+type Override struct {
+	Content     *string
+	Title       *string
+	Description *string
+
+	// Formatted description used instead of the message's description
+	RichDescription RichText
+
+	// Replace the message's fields, buttons or select menus when non-nil. An
+	// empty slice removes them.
+	Fields  []Field
+	Buttons [][]Button
+	Selects []Select
+
+	// Embeds added after the message's own
+	Embeds []Embed
+
+	// Hooks modifying the rendered payload before it is sent, for anything the
+	// message cannot express. Only the hook matching the platform is called.
+	Discord  func(data *discordgo.InteractionResponseData)
+	Telegram func(text string, markup models.ReplyMarkup) (string, models.ReplyMarkup)
+	Guilded  func(msg *guildedgo.MessageObject)
+}
+
+// forPlatform returns a copy of the message with the platform's override
+// merged in. The copy only keeps the override's hooks, so merging it again
+// has no effect.
+func (m *Message) forPlatform(p Platform) *Message {
+	o, ok := m.Overrides[p]
+	if !ok || o == nil {
+		return m
+	}
+
+	res := *m
+	if o.Content != nil {
+		res.Content = *o.Content
+	}
+
+	if o.Title != nil {
+		res.Title = *o.Title
+	}
+
+	if o.Description != nil {
+		res.Description, res.RichDescription = *o.Description, nil
+	}
+
+	if o.RichDescription != nil {
+		res.RichDescription = o.RichDescription
+	}
+
+	if o.Fields != nil {
+		res.Fields = o.Fields
+	}
+
+	if o.Buttons != nil {
+		res.Buttons = o.Buttons
+	}
+
+	if o.Selects != nil {
+		res.Selects = o.Selects
+	}
+
+	if o.Embeds != nil {
+		res.Embeds = append(append([]Embed(nil), m.Embeds...), o.Embeds...)
+	}
+
+	res.Overrides = map[Platform]*Override{p: {
+		Discord:  o.Discord,
+		Telegram: o.Telegram,
+		Guilded:  o.Guilded,
+	}}
+
+	return &res
+}
+
+// override returns the platform's override, or an empty one
+func (m *Message) override(p Platform) *Override {
+	if o := m.Overrides[p]; o != nil {
+		return o
+	}
+
+	return &Override{}
+}
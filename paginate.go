@@ -0,0 +1,90 @@
+package crossbot
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultPaginatorTimeout is how long page buttons work if the paginator does
+// not specify a timeout
+const defaultPaginatorTimeout = 15 * time.Minute
+
+// Paginator shows a long list one page at a time, with buttons editing the
+// message in place to move between pages. Pages of responses only the user
+// can see are edited in place as well.
+type Paginator struct {
+	// Number of pages
+	Pages int
+
+	// Function returning the page's message. Pages are numbered from 0.
+	Render func(page int) *Message
+
+	// Platform user ID of the only user allowed to turn pages, usually the
+	// invoking user's (Request.UserID). Anyone may if empty.
+	OwnerID string
+
+	// Time the buttons keep working after each page turn. Defaults to 15
+	// minutes.
+	Timeout time.Duration
+}
+
+// Page renders the page along with first, previous, next and last buttons and
+// a page indicator. Out of range pages are clamped, and paginators without
+// pages show that there is nothing to show.
+func (p *Paginator) Page(page int) *Message {
+	if p.Pages <= 0 {
+		return &Message{Title: "Nothing to show"}
+	}
+
+	page = max(0, min(page, p.Pages-1))
+
+	msg := p.Render(page)
+	if msg == nil {
+		msg = &Message{}
+	}
+
+	if p.Pages <= 1 {
+		return msg
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPaginatorTimeout
+	}
+	expires := time.Now().Add(timeout)
+
+	turn := func(label string, to int) Button {
+		to = max(0, min(to, p.Pages-1))
+		return Button{
+			Label:    label,
+			Style:    ButtonStyleSecondary,
+			Disabled: to == page,
+			Callback: Callback{
+				Action:  CallbackActionEditMessage,
+				OwnerID: p.OwnerID,
+				Expires: expires,
+				Function: func(map[string]string) *Message {
+					return p.Page(to)
+				},
+				continuation: true,
+			},
+		}
+	}
+
+	// The indicator has no function, so pressing it does nothing
+	indicator := Button{
+		Label:    fmt.Sprintf("%d / %d", page+1, p.Pages),
+		Style:    ButtonStyleSecondary,
+		Callback: Callback{Action: CallbackActionEditMessage, OwnerID: p.OwnerID, Expires: expires},
+	}
+
+	msg.Buttons = append(msg.Buttons, []Button{
+		turn("⏮", 0),
+		turn("◀", page-1),
+		indicator,
+		turn("▶", page+1),
+		turn("⏭", p.Pages-1),
+	})
+
+	return msg
+}
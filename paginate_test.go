@@ -0,0 +1,133 @@
+package crossbot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPaginatorPage(t *testing.T) {
+	render := func(page int) *Message { return &Message{Title: fmt.Sprintf("page %d", page)} }
+
+	tests := []struct {
+		name      string
+		pages     int
+		page      int
+		title     string
+		indicator string
+		disabled  []bool
+	}{
+		{"empty", 0, 0, "Nothing to show", "", nil},
+		{"single", 1, 0, "page 0", "", nil},
+		{"first", 3, 0, "page 0", "1 / 3", []bool{true, true, false, false, false}},
+		{"middle", 3, 1, "page 1", "2 / 3", []bool{false, false, false, false, false}},
+		{"last", 3, 2, "page 2", "3 / 3", []bool{false, false, false, true, true}},
+		{"before first", 3, -4, "page 0", "1 / 3", []bool{true, true, false, false, false}},
+		{"after last", 3, 9, "page 2", "3 / 3", []bool{false, false, false, true, true}},
+	}
+
+	for _, tt := range tests {
+		p := &Paginator{Pages: tt.pages, Render: render}
+		msg := p.Page(tt.page)
+
+		if msg.Title != tt.title {
+			t.Errorf("%s: shows %q, want %q", tt.name, msg.Title, tt.title)
+		}
+
+		if tt.indicator == "" {
+			if len(msg.Buttons) != 0 {
+				t.Errorf("%s: has buttons %v", tt.name, msg.Buttons)
+			}
+
+			continue
+		}
+
+		if len(msg.Buttons) != 1 || len(msg.Buttons[0]) != 5 {
+			t.Fatalf("%s: has buttons %v", tt.name, msg.Buttons)
+		}
+
+		row := msg.Buttons[0]
+		if row[2].Label != tt.indicator {
+			t.Errorf("%s: indicator %q, want %q", tt.name, row[2].Label, tt.indicator)
+		}
+
+		for i, b := range row {
+			if b.Disabled != tt.disabled[i] {
+				t.Errorf("%s: button %q disabled = %t, want %t", tt.name, b.Label, b.Disabled, tt.disabled[i])
+			}
+		}
+	}
+}
+
+func TestPaginatorTurn(t *testing.T) {
+	p := &Paginator{
+		Pages:   5,
+		OwnerID: "1",
+		Timeout: time.Minute,
+		Render:  func(page int) *Message { return &Message{Title: fmt.Sprint(page)} },
+	}
+
+	row := p.Page(2).Buttons[0]
+	want := []string{"0", "1", "", "3", "4"}
+
+	for i, b := range row {
+		cb := b.Callback
+		if cb.OwnerID != "1" || time.Until(cb.Expires) > time.Minute || time.Until(cb.Expires) <= 0 {
+			t.Errorf("button %q is owned by %q and expires at %s", b.Label, cb.OwnerID, cb.Expires)
+		}
+
+		if want[i] == "" {
+			if !cb.noop() {
+				t.Errorf("indicator does something")
+			}
+
+			continue
+		}
+
+		if !cb.continuation {
+			t.Errorf("button %q is charged to the rate limits", b.Label)
+		}
+
+		if got := cb.Function(nil).Title; got != want[i] {
+			t.Errorf("button %q turns to page %s, want %s", b.Label, got, want[i])
+		}
+	}
+}
+
+func TestPaginatorNilPage(t *testing.T) {
+	p := &Paginator{Pages: 2, Render: func(int) *Message { return nil }}
+	if msg := p.Page(0); msg == nil || len(msg.Buttons) != 1 {
+		t.Errorf("nil page rendered as %v", msg)
+	}
+}
+
+func TestSweepCallbacks(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		kept    bool
+	}{
+		{"never expires", time.Time{}, true},
+		{"not expired", now.Add(time.Minute), true},
+		{"recently expired", now.Add(-time.Minute), true},
+		{"long expired", now.Add(-callbackExpiredRetention - time.Minute), false},
+	}
+
+	ids := make([]string, len(tests))
+	for i, tt := range tests {
+		ids[i] = Callback{Expires: tt.expires}.Register()
+	}
+
+	callbackCache.Lock()
+	callbackCache.swept = time.Time{}
+	sweepCallbacks(now)
+	callbackCache.Unlock()
+
+	for i, tt := range tests {
+		if _, kept := LookupCallback(ids[i]); kept != tt.kept {
+			t.Errorf("%s: callback kept = %t, want %t", tt.name, kept, tt.kept)
+		}
+	}
+}
@@ -8,6 +8,7 @@ const (
 	PlatformUndefined Platform = iota
 	PlatformDiscord
 	PlatformTelegram
+	PlatformGuilded
 )
 
 func GetStringPlatform(s string) (Platform, error) {
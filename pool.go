@@ -0,0 +1,159 @@
+package crossbot
+
+import (
+	"context"
+	"sync"
+)
+
+// Backpressure decides what happens to requests while every worker is busy
+type Backpressure uint8
+
+const (
+	// Requests wait for a free worker, and are rejected once the queue is full
+	BackpressureQueue Backpressure = iota
+
+	// Requests are answered with a message asking to try again later
+	BackpressureReject
+
+	// Requests are ignored without a response
+	BackpressureDrop
+)
+
+type (
+	// workerPool limits how many handlers run at once
+	workerPool struct {
+		slots chan struct{}
+
+		mu      sync.Mutex
+		waiting int
+	}
+
+	// workerPools holds the config's pool and those of commands with
+	// concurrency limits, created on first use
+	workerPools struct {
+		mu       sync.Mutex
+		global   *workerPool
+		commands map[*Command]*workerPool
+	}
+)
+
+// busy is the response to requests turned away while every worker is busy.
+// It is only ephemeral on Discord, as Telegram would send it privately.
+func busy(p Platform) *Message {
+	msg := &Message{Title: "🚦 The bot is busy right now", Description: "Please try again in a moment."}
+	if p == PlatformDiscord {
+		msg.Visibility = VisibilityEphemeral
+	}
+
+	return msg
+}
+
+// acquire takes a free worker, waiting for one if the backpressure and queue
+// length allow it. False is returned if the request was turned away.
+func (p *workerPool) acquire(ctx context.Context, bp Backpressure, queue int) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if bp != BackpressureQueue {
+		return false
+	}
+
+	p.mu.Lock()
+	if queue > 0 && p.waiting >= queue {
+		p.mu.Unlock()
+		return false
+	}
+	p.waiting++
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a worker taken with acquire
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// pools returns the pools the command's requests must take a worker from, the
+// command's own first
+func (c *Config) pools(cmd *Command) []*workerPool {
+	c.workers.mu.Lock()
+	defer c.workers.mu.Unlock()
+
+	var pools []*workerPool
+	if cmd != nil && cmd.Concurrency > 0 {
+		if c.workers.commands == nil {
+			c.workers.commands = make(map[*Command]*workerPool)
+		}
+
+		p, ok := c.workers.commands[cmd]
+		if !ok {
+			p = &workerPool{slots: make(chan struct{}, cmd.Concurrency)}
+			c.workers.commands[cmd] = p
+		}
+		pools = append(pools, p)
+	}
+
+	if c.Workers > 0 {
+		if c.workers.global == nil {
+			c.workers.global = &workerPool{slots: make(chan struct{}, c.Workers)}
+		}
+		pools = append(pools, c.workers.global)
+	}
+
+	return pools
+}
+
+// concurrencyMiddleware runs the handler once a worker of the config and
+// command is free, applying the config's backpressure otherwise
+func (c *Config) concurrencyMiddleware(cmd *Command) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) *Message {
+			queue := c.QueueSize
+			if cmd != nil && cmd.QueueSize > 0 {
+				queue = cmd.QueueSize
+			}
+
+			// Workers already taken are released once the handler returns, or
+			// when a later pool turns the request away
+			for _, p := range c.pools(cmd) {
+				if p.acquire(req.Context(), c.Backpressure, queue) {
+					defer p.release()
+					continue
+				}
+
+				var msg *Message
+				if c.Backpressure != BackpressureDrop {
+					msg = busy(req.Target.Platform)
+				}
+
+				// Callbacks are answered with a notice only the user sees, if
+				// any, as the message would replace the one they belong to
+				if req.Callback {
+					if msg == nil {
+						msg = &Message{}
+					}
+					msg.notice = true
+				}
+
+				return msg
+			}
+
+			return next(req)
+		}
+	}
+}
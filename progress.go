@@ -0,0 +1,510 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	// discordDeferAfter is how long a handler may run before its interaction is
+	// deferred, as Discord requires a response within 3 seconds
+	discordDeferAfter = 2 * time.Second
+
+	// telegramTypingInterval is how often the typing action is repeated, as it
+	// is shown for 5 seconds at most
+	telegramTypingInterval = 4 * time.Second
+)
+
+// discordResponder answers a command or component interaction, either
+// directly or through a deferred response edited once the handler is done
+type discordResponder struct {
+	// Context of the received interaction
+	ctx context.Context
+
+	c *Config
+	s *discordgo.Session
+	i *discordgo.Interaction
+
+	// Chat the interaction happened in
+	target Target
+
+	// Invoking user and the command's visibility
+	userID     string
+	visibility Visibility
+
+	// Set for component interactions, whose original response is the message
+	// the component belongs to. Other responses are sent as followups.
+	component bool
+
+	mu        sync.Mutex
+	responded bool
+	ephemeral bool
+	done      bool
+}
+
+// run runs the handler, deferring the interaction if it takes too long,
+// then responds with its result
+func (r *discordResponder) run(handler func() *Message) {
+	result := make(chan *Message, 1)
+	go func() { result <- handler() }()
+
+	var msg *Message
+	select {
+	case msg = <-result:
+	case <-time.After(discordDeferAfter):
+		r.deferResponse()
+		msg = <-result
+	}
+
+	r.finish(msg)
+}
+
+// runComponent runs a component's callback, deferring the interaction if it
+// takes too long, then applies its result to the message the component
+// belongs to. Results only the user may see, alerts, prompts and notices of
+// failures are responded with instead, unless the message is only visible to the user
+// already.
+func (r *discordResponder) runComponent(cb Callback, ref *MessageRef, handler func() *Message) {
+	result := make(chan *Message, 1)
+	go func() { result <- handler() }()
+
+	var msg *Message
+	select {
+	case msg = <-result:
+	case <-time.After(discordDeferAfter):
+		r.deferResponse()
+		msg = <-result
+	}
+
+	var err error
+	switch {
+	case msg == nil, msg.notice && msg.Title == "":
+		r.deferResponse()
+
+	case msg.notice, cb.Action == CallbackActionAlert, cb.Action == CallbackActionPrompt:
+		notice := *msg
+		notice.Visibility = VisibilityEphemeral
+		r.finish(&notice)
+
+	case cb.Action == CallbackActionDeleteMessage:
+		r.deferResponse()
+		err = r.c.Delete(r.ctx, ref)
+
+	case cb.Action == CallbackActionEditMessage && discordPrivate(r.i, msg.visibility(r.visibility)):
+		err = r.update(msg)
+
+	case msg.visibility(r.visibility) != VisibilityPublic:
+		r.finish(msg)
+
+	case cb.Action == CallbackActionCreateMessage:
+		r.deferResponse()
+		_, err = r.c.send(r.ctx, ref.Target, msg, "")
+
+	default:
+		r.deferResponse()
+	}
+
+	if err != nil {
+		r.c.logger().Error("Failed to handle Discord callback", "error", err)
+	}
+}
+
+// deferResponse shows the "thinking" state until the response is edited in.
+// Component interactions are acknowledged without changing their message.
+func (r *discordResponder) deferResponse() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.responded {
+		return
+	}
+
+	resp := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}
+	ephemeral := r.visibility != VisibilityPublic && !r.component
+	switch {
+	case r.component:
+		resp.Type = discordgo.InteractionResponseDeferredMessageUpdate
+	case ephemeral:
+		resp.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+
+	ctx, span := r.c.startSpan(r.ctx, "defer", r.target)
+	err := r.s.InteractionRespond(r.i, resp, discordgo.WithContext(ctx))
+	endSpan(span, err)
+	if err != nil {
+		r.c.logger().Error("Failed to defer Discord interaction", "error", err)
+		return
+	}
+
+	r.responded, r.ephemeral = true, ephemeral
+}
+
+// progress shows an intermediate message as the response
+func (r *discordResponder) progress(ctx context.Context, msg *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.done {
+		return nil
+	}
+
+	msg = r.c.loadAttachments(ctx, msg, PlatformDiscord)
+	msg, _ = r.c.degrade(msg, PlatformDiscord)
+	msg, err := r.c.fitOne(msg, PlatformDiscord)
+	if err != nil {
+		return err
+	}
+
+	return r.respond(ctx, msg, r.visibility != VisibilityPublic)
+}
+
+// finish responds with the handler's result. Remaining parts of split
+// messages are sent as followups. Private results are sent as a DM, with an
+// ephemeral acknowledgement as the response.
+func (r *discordResponder) finish(msg *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.done = true
+
+	// Dropped requests leave no response behind
+	if msg == nil {
+		if r.responded && !r.component {
+			if err := r.s.InteractionResponseDelete(r.i); err != nil {
+				r.c.logger().Error("Failed to delete Discord response", "error", err)
+			}
+		}
+
+		return
+	}
+
+	vis := msg.visibility(r.visibility)
+	if vis == VisibilityPrivate {
+		ack := privateAck
+		if _, err := r.c.sendPrivate(r.ctx, PlatformDiscord, r.userID, msg); err != nil {
+			r.c.logger().Error("Failed to send Discord DM", "error", err)
+			ack = privateFailed
+		}
+
+		msg, vis = ack, VisibilityEphemeral
+	}
+	ephemeral := vis != VisibilityPublic
+
+	msg = r.c.loadAttachments(r.ctx, msg, PlatformDiscord)
+	msg, choices := r.c.degrade(msg, PlatformDiscord)
+	if len(choices) > 0 {
+		r.c.setChoices(r.target, nil, choices)
+	}
+
+	parts, err := r.c.fit(msg, PlatformDiscord)
+	if err != nil {
+		r.c.logger().Error("Failed to fit Discord response", "error", err)
+		parts = []*Message{tooLongMessage}
+	}
+
+	if err := r.respond(r.ctx, parts[0], ephemeral); err != nil {
+		r.c.logger().Error("Failed to respond to Discord interaction", "error", err)
+		return
+	}
+
+	for _, part := range parts[1:] {
+		if err := r.followup(r.ctx, part, ephemeral); err != nil {
+			r.c.logger().Error("Failed to send Discord followup message", "error", err)
+		}
+	}
+}
+
+// update responds by editing the message the interaction's component belongs
+// to, which works for ephemeral messages too. Deferred interactions edit it
+// as their original response.
+func (r *discordResponder) update(msg *Message) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.done = true
+
+	msg = r.c.loadAttachments(r.ctx, msg, PlatformDiscord)
+	msg, _ = r.c.degrade(msg, PlatformDiscord)
+	msg, err = r.c.fitOne(msg, PlatformDiscord)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := r.c.startSpan(r.ctx, "respond", r.target)
+	defer func() { endSpan(span, err) }()
+
+	// Empty slices clear the previous embeds and components
+	resp := msg.Discord()
+	if resp.Embeds == nil {
+		resp.Embeds = []*discordgo.MessageEmbed{}
+	}
+	if resp.Components == nil {
+		resp.Components = []discordgo.MessageComponent{}
+	}
+
+	if r.responded {
+		_, err = r.s.InteractionResponseEdit(r.i, &discordgo.WebhookEdit{
+			Content:    &resp.Content,
+			Embeds:     &resp.Embeds,
+			Components: &resp.Components,
+			Files:      resp.Files,
+		}, discordgo.WithContext(ctx))
+
+		return err
+	}
+
+	err = r.s.InteractionRespond(r.i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: resp,
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	r.responded = true
+	return nil
+}
+
+// respond sends the initial response, or edits it once sent. The caller must
+// hold the lock.
+func (r *discordResponder) respond(ctx context.Context, msg *Message, ephemeral bool) (err error) {
+	ctx, span := r.c.startSpan(ctx, "respond", r.target)
+	defer func() { endSpan(span, err) }()
+
+	resp := msg.Discord()
+	if !r.responded {
+		if ephemeral {
+			resp.Flags |= discordgo.MessageFlagsEphemeral
+		}
+
+		err := r.s.InteractionRespond(r.i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: resp,
+		}, discordgo.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		r.responded, r.ephemeral = true, ephemeral
+		return nil
+	}
+
+	// The original response of components is their message, which is left
+	// alone
+	if r.component {
+		return r.followup(ctx, msg, ephemeral)
+	}
+
+	// Responses cannot be made ephemeral once sent, so a public response is
+	// replaced by an ephemeral followup
+	if ephemeral && !r.ephemeral {
+		if err := r.s.InteractionResponseDelete(r.i, discordgo.WithContext(ctx)); err != nil {
+			return err
+		}
+
+		return r.followup(ctx, msg, true)
+	}
+
+	// Empty slices clear the previous embeds and components
+	embeds, components := resp.Embeds, resp.Components
+	if embeds == nil {
+		embeds = []*discordgo.MessageEmbed{}
+	}
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+
+	_, err = r.s.InteractionResponseEdit(r.i, &discordgo.WebhookEdit{
+		Content:    &resp.Content,
+		Embeds:     &embeds,
+		Components: &components,
+		Files:      resp.Files,
+	}, discordgo.WithContext(ctx))
+
+	return err
+}
+
+// followup sends an additional message after the response
+func (r *discordResponder) followup(ctx context.Context, msg *Message, ephemeral bool) (err error) {
+	ctx, span := r.c.startSpan(ctx, "followup", r.target)
+	defer func() { endSpan(span, err) }()
+
+	resp := msg.Discord()
+	params := &discordgo.WebhookParams{
+		Content:    resp.Content,
+		Embeds:     resp.Embeds,
+		Components: resp.Components,
+		Files:      resp.Files,
+	}
+
+	if ephemeral {
+		params.Flags = discordgo.MessageFlagsEphemeral
+	}
+
+	_, err = r.s.FollowupMessageCreate(r.i, true, params, discordgo.WithContext(ctx))
+	return err
+}
+
+// chatResponder shows a command's progress as a reply edited in place, for
+// requests that did not come with an interaction to respond to. Chats have no
+// ephemeral messages, so responses that are not public are sent privately,
+// leaving an acknowledgement in the invoking chat.
+type chatResponder struct {
+	c *Config
+
+	// Chat and message the command was invoked with
+	chat    Target
+	replyTo string
+
+	// Invoking user and the command's visibility
+	userID     string
+	visibility Visibility
+
+	mu   sync.Mutex
+	ref  *MessageRef
+	done bool
+}
+
+// destination returns the chat a response with the visibility is sent to
+func (p *chatResponder) destination(vis Visibility) (Target, error) {
+	if vis == VisibilityPublic {
+		return p.chat, nil
+	}
+
+	return p.c.privateTarget(p.chat.Platform, p.userID)
+}
+
+// send posts a message to the target, replying to the command if it is in
+// the invoking chat
+func (p *chatResponder) send(ctx context.Context, target Target, msg *Message) (*MessageRef, error) {
+	replyTo := ""
+	if target == p.chat {
+		replyTo = p.replyTo
+	}
+
+	return p.c.send(ctx, target, msg, replyTo)
+}
+
+// progress sends the intermediate message, or edits the previous one
+func (p *chatResponder) progress(ctx context.Context, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return nil
+	}
+
+	if p.ref != nil {
+		return p.c.Edit(ctx, p.ref, msg)
+	}
+
+	to, err := p.destination(p.visibility)
+	if err != nil {
+		return err
+	}
+
+	ref, err := p.send(ctx, to, msg)
+	if err != nil {
+		return err
+	}
+
+	p.ref = ref
+	return nil
+}
+
+// finish replaces the progress message with the handler's result. Results
+// with attachments cannot be edited in, so they are sent as a new message
+// instead.
+func (p *chatResponder) finish(ctx context.Context, msg *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done = true
+
+	// Dropped requests leave no response behind
+	if msg == nil {
+		if p.ref != nil {
+			if err := p.c.Delete(ctx, p.ref); err != nil {
+				p.c.logger().Error("Failed to delete progress message", "error", err)
+			}
+		}
+
+		return
+	}
+
+	to, err := p.destination(msg.visibility(p.visibility))
+	switch {
+	case err != nil:
+
+	case p.ref != nil && p.ref.Target == to && len(msg.Attachments) == 0 && len(msg.images()) == 0:
+		err = p.c.Edit(ctx, p.ref, msg)
+
+	default:
+		if p.ref != nil {
+			if err := p.c.Delete(ctx, p.ref); err != nil {
+				p.c.logger().Error("Failed to delete progress message", "error", err)
+			}
+		}
+
+		_, err = p.send(ctx, to, msg)
+	}
+
+	if errors.As(err, new(LimitViolation)) {
+		p.c.logger().Error("Failed to fit response", "error", err)
+		_, err = p.send(ctx, to, tooLongMessage)
+	}
+	if err != nil {
+		p.c.logger().Error("Failed to send response", append(p.c.targetAttrs(p.chat), "error", err)...)
+	}
+
+	if to == p.chat {
+		return
+	}
+
+	// Acknowledge private responses without revealing them
+	ack := privateAck
+	if err != nil {
+		ack = privateFailed
+	}
+
+	if _, err := p.send(ctx, p.chat, ack); err != nil {
+		p.c.logger().Error("Failed to send response", append(p.c.targetAttrs(p.chat), "error", err)...)
+	}
+}
+
+// telegramTyping shows the typing action in the chat until stopped
+func (c *Config) telegramTyping(ctx context.Context, b *bot.Bot, target Target) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	threadID, _ := target.telegramThread()
+	params := &bot.SendChatActionParams{
+		ChatID:          target.telegramChat(),
+		MessageThreadID: threadID,
+		Action:          models.ChatActionTyping,
+	}
+
+	go func() {
+		ticker := time.NewTicker(telegramTypingInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := b.SendChatAction(ctx, params); err != nil && ctx.Err() == nil {
+				c.logger().Error("Failed to send Telegram chat action", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return cancel
+}
@@ -0,0 +1,251 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultSendRetries is how many times failed deliveries are retried if
+	// the config does not specify it
+	defaultSendRetries = 3
+
+	// Backoff between retries of transient failures, doubling each attempt
+	retryBackoff    = time.Second
+	retryBackoffMax = 30 * time.Second
+)
+
+// errPartSkipped is returned for parts of split messages that were not sent
+// because a previous part failed
+var errPartSkipped = errors.New("previous part failed")
+
+type (
+	// Delivery is the result of sending a message
+	Delivery struct {
+		// Reference to the first sent message, if delivered
+		Ref *MessageRef
+		Err error
+	}
+
+	// outbox holds a queue of pending requests per chat, so that requests to
+	// the same chat are made in order while chats do not hold each other up
+	outbox struct {
+		mu     sync.Mutex
+		queues map[Target]*chatQueue
+	}
+
+	chatQueue struct {
+		jobs []*outboxJob
+	}
+
+	outboxJob struct {
+		ctx    context.Context
+		name   string
+		target Target
+		do     func(ctx context.Context) error
+		done   chan error
+	}
+
+	// permanentError marks failures that must not be retried
+	permanentError struct {
+		error
+	}
+
+	// transientError marks failures worth retrying that have no type of their
+	// own, such as Telegram server errors
+	transientError struct {
+		error
+	}
+)
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+func (e transientError) Unwrap() error {
+	return e.error
+}
+
+// telegramError classifies the error of a Telegram request by the library's
+// typed errors. Client errors are permanent, rate limits keep their type to
+// be waited out, and other errors are transient.
+func telegramError(err error) error {
+	var (
+		rate    *bot.TooManyRequestsError
+		migrate *bot.MigrateError
+	)
+
+	switch {
+	case err == nil:
+		return nil
+
+	case errors.As(err, &rate),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return err
+
+	case errors.Is(err, bot.ErrorBadRequest),
+		errors.Is(err, bot.ErrorForbidden),
+		errors.Is(err, bot.ErrorUnauthorized),
+		errors.Is(err, bot.ErrorNotFound),
+		errors.Is(err, bot.ErrorConflict),
+		errors.As(err, &migrate):
+		return permanent(err)
+
+	default:
+		return transientError{err}
+	}
+}
+
+// enqueue adds the requests to the chat's queue in order, returning a function
+// waiting for them to finish. The first error is returned. Each request is
+// traced as a span with the name.
+func (c *Config) enqueue(ctx context.Context, target Target, name string, do ...func(ctx context.Context) error) (wait func() error) {
+	// Threads share their chat's queue, as platform rate limits are per chat
+	key := Target{Platform: target.Platform, ChatID: target.ChatID}
+
+	jobs := make([]*outboxJob, len(do))
+	for i, fn := range do {
+		jobs[i] = &outboxJob{ctx: ctx, name: name, target: target, do: fn, done: make(chan error, 1)}
+	}
+
+	c.outbox.mu.Lock()
+	if c.outbox.queues == nil {
+		c.outbox.queues = make(map[Target]*chatQueue)
+	}
+
+	q, running := c.outbox.queues[key]
+	if !running {
+		q = &chatQueue{}
+		c.outbox.queues[key] = q
+	}
+	q.jobs = append(q.jobs, jobs...)
+	c.outbox.mu.Unlock()
+
+	if !running {
+		go c.drain(key, q)
+	}
+
+	return func() error {
+		var first error
+		for _, job := range jobs {
+			if err := <-job.done; err != nil && first == nil {
+				first = err
+			}
+		}
+
+		return first
+	}
+}
+
+// drain runs the queue's requests until it is empty
+func (c *Config) drain(key Target, q *chatQueue) {
+	for {
+		c.outbox.mu.Lock()
+		if len(q.jobs) == 0 {
+			delete(c.outbox.queues, key)
+			c.outbox.mu.Unlock()
+			return
+		}
+
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		c.outbox.mu.Unlock()
+
+		err := c.deliver(job)
+		if err != nil && !errors.Is(err, errPartSkipped) {
+			c.metric().sendFailures.WithLabelValues(platformName(key.Platform)).Inc()
+		}
+		job.done <- err
+	}
+}
+
+// deliver runs the request, retrying rate limited and transient failures
+func (c *Config) deliver(job *outboxJob) (err error) {
+	ctx, span := c.startSpan(job.ctx, job.name, job.target)
+	defer func() { endSpan(span, err) }()
+
+	retries := c.SendRetries
+	if retries == 0 {
+		retries = defaultSendRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := job.ctx.Err(); err != nil {
+			return err
+		}
+
+		err := job.do(ctx)
+		if err == nil {
+			return nil
+		}
+
+		wait, ok := retryDelay(err, attempt)
+		if !ok || attempt >= retries {
+			return err
+		}
+
+		c.logger().Warn("Retrying request", "retry_in", wait, "attempt", attempt+1, "error", err)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
+
+		select {
+		case <-time.After(wait):
+		case <-job.ctx.Done():
+			return fmt.Errorf("%w (gave up retrying: %w)", err, job.ctx.Err())
+		}
+	}
+}
+
+// retryDelay returns how long to wait before retrying the failed request, and
+// whether it should be retried at all
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	backoff := min(retryBackoff<<attempt, retryBackoffMax)
+
+	var (
+		permanentErr permanentError
+		transientErr transientError
+		telegramRate *bot.TooManyRequestsError
+		discordRate  *discordgo.RateLimitError
+		discordErr   *discordgo.RESTError
+		netErr       net.Error
+	)
+
+	switch {
+	case errors.As(err, &permanentErr),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, errPartSkipped):
+		return 0, false
+
+	case errors.As(err, &telegramRate):
+		return time.Duration(telegramRate.RetryAfter) * time.Second, true
+
+	case errors.As(err, &discordRate):
+		return discordRate.RetryAfter, true
+
+	case errors.As(err, &discordErr):
+		return backoff, discordErr.Response != nil && discordErr.Response.StatusCode >= 500
+
+	case errors.As(err, &netErr), errors.As(err, &transientErr):
+		return backoff, true
+
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,168 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+)
+
+func TestTelegramError(t *testing.T) {
+	rate := &bot.TooManyRequestsError{Message: "slow down", RetryAfter: 3}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"bad request", fmt.Errorf("%w, message is too long", bot.ErrorBadRequest), "permanent"},
+		{"forbidden", fmt.Errorf("%w, bot was blocked", bot.ErrorForbidden), "permanent"},
+		{"unauthorized", bot.ErrorUnauthorized, "permanent"},
+		{"not found", bot.ErrorNotFound, "permanent"},
+		{"conflict", bot.ErrorConflict, "permanent"},
+		{"migrated", &bot.MigrateError{Message: "migrated", MigrateToChatID: -100}, "permanent"},
+		{"rate limited", rate, "unchanged"},
+		{"canceled", context.Canceled, "unchanged"},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), "unchanged"},
+		{"server error", errors.New("error response from telegram, status code 502"), "transient"},
+	}
+
+	for _, tt := range tests {
+		got := telegramError(tt.err)
+
+		var (
+			permanentErr permanentError
+			transientErr transientError
+			kind         string
+		)
+		switch {
+		case errors.As(got, &permanentErr):
+			kind = "permanent"
+		case errors.As(got, &transientErr):
+			kind = "transient"
+		case got == tt.err:
+			kind = "unchanged"
+		}
+
+		if kind != tt.want {
+			t.Errorf("%s: telegramError(%v) is %q, want %q", tt.name, tt.err, kind, tt.want)
+		}
+
+		if !errors.Is(got, tt.err) {
+			t.Errorf("%s: telegramError(%v) does not wrap the error", tt.name, tt.err)
+		}
+	}
+
+	if telegramError(nil) != nil {
+		t.Error("telegramError(nil) is not nil")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	restError := func(status int) error {
+		return &discordgo.RESTError{Response: &http.Response{StatusCode: status}}
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		want    time.Duration
+		retry   bool
+	}{
+		{"permanent", permanent(errors.New("bad request")), 0, 0, false},
+		{"canceled", context.Canceled, 0, 0, false},
+		{"deadline", context.DeadlineExceeded, 0, 0, false},
+		{"part skipped", errPartSkipped, 0, 0, false},
+		{"unknown", errors.New("unknown"), 0, 0, false},
+		{"telegram rate limit", &bot.TooManyRequestsError{RetryAfter: 7}, 0, 7 * time.Second, true},
+		{"discord rate limit", &discordgo.RateLimitError{RateLimit: &discordgo.RateLimit{TooManyRequests: &discordgo.TooManyRequests{RetryAfter: 1500 * time.Millisecond}}}, 2, 1500 * time.Millisecond, true},
+		{"discord server error", restError(http.StatusBadGateway), 0, time.Second, true},
+		{"discord client error", restError(http.StatusForbidden), 0, time.Second, false},
+		{"discord no response", &discordgo.RESTError{}, 0, time.Second, false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, 1, 2 * time.Second, true},
+		{"transient", transientError{errors.New("status code 500")}, 2, 4 * time.Second, true},
+		{"backoff cap", transientError{errors.New("status code 500")}, 10, retryBackoffMax, true},
+		{"wrapped permanent", fmt.Errorf("send: %w", permanent(errors.New("forbidden"))), 0, 0, false},
+		{"classified telegram", telegramError(fmt.Errorf("%w, chat not found", bot.ErrorBadRequest)), 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, retry := retryDelay(tt.err, tt.attempt)
+		if retry != tt.retry {
+			t.Errorf("%s: retryDelay retries = %t, want %t", tt.name, retry, tt.retry)
+		}
+
+		if retry && got != tt.want {
+			t.Errorf("%s: retryDelay waits %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		wantRuns int
+		wantErr  bool
+	}{
+		{"success", nil, 0, 1, false},
+		{"recovers", &bot.TooManyRequestsError{}, 2, 3, false},
+		{"exhausted", &bot.TooManyRequestsError{}, 10, defaultSendRetries + 1, true},
+		{"permanent", permanent(errors.New("forbidden")), 10, 1, true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		runs := 0
+		wait := c.enqueue(context.Background(), Target{Platform: PlatformTelegram, ChatID: "chat"}, "test", func(context.Context) error {
+			runs++
+			if runs <= tt.failures {
+				return tt.err
+			}
+
+			return nil
+		})
+
+		if err := wait(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: delivery error = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+
+		if runs != tt.wantRuns {
+			t.Errorf("%s: request ran %d times, want %d", tt.name, runs, tt.wantRuns)
+		}
+	}
+}
+
+func TestEnqueueOrder(t *testing.T) {
+	c := &Config{}
+	target := Target{Platform: PlatformDiscord, ChatID: "chat"}
+
+	var order []int
+	var waits []func() error
+	for i := range 5 {
+		waits = append(waits, c.enqueue(context.Background(), target, "test", func(context.Context) error {
+			order = append(order, i)
+			return nil
+		}))
+	}
+
+	for _, wait := range waits {
+		if err := wait(); err != nil {
+			t.Fatalf("delivery failed: %v", err)
+		}
+	}
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("requests ran in order %v", order)
+		}
+	}
+}
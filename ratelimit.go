@@ -0,0 +1,344 @@
+package crossbot
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// rateFlushDelay is how long changed buckets wait before being persisted,
+	// so that bursts of requests are written at once
+	rateFlushDelay = 5 * time.Second
+
+	// rateSweepInterval is how often buckets are checked for eviction
+	rateSweepInterval = time.Minute
+
+	// rateIdleTimeout is how long persisted buckets stay in memory unused
+	rateIdleTimeout = 10 * time.Minute
+)
+
+// RateScope decides who shares a rate limit's bucket
+type RateScope uint8
+
+const (
+	// Each user has their own bucket, shared across platforms through
+	// Config.Identity
+	RateScopeUser RateScope = iota
+
+	// Each chat has its own bucket
+	RateScopeChat
+
+	// Each command has its own bucket
+	RateScopeCommand
+
+	// Everyone shares a single bucket
+	RateScopeGlobal
+)
+
+type (
+	// RateLimit allows Burst uses at once, regaining one every Every. Limits
+	// on the config count every command together, unless scoped per command,
+	// while limits on a command only count that command.
+	RateLimit struct {
+		Scope RateScope
+		Burst int
+		Every time.Duration
+	}
+
+	// rateBucket is the state of a single token bucket
+	rateBucket struct {
+		Tokens  float64
+		Updated time.Time
+
+		// Limit the bucket was last used with
+		limit RateLimit
+	}
+
+	rateLimiter struct {
+		mu      sync.Mutex
+		buckets map[string]*rateBucket
+		swept   time.Time
+
+		// Keys of buckets changed or evicted since they were last persisted,
+		// and the timer persisting them
+		dirty map[string]bool
+		flush *time.Timer
+
+		// Held while persisting, keeping writes in order
+		flushMu sync.Mutex
+	}
+)
+
+// validateRateLimits checks that every limit allows at least one use and
+// refills over time
+func validateRateLimits(limits []RateLimit) error {
+	for i, l := range limits {
+		if l.Burst <= 0 {
+			return fmt.Errorf("rate limit %d must have a positive burst", i+1)
+		}
+
+		if l.Every <= 0 {
+			return fmt.Errorf("rate limit %d must have a positive interval", i+1)
+		}
+	}
+
+	return nil
+}
+
+// identity returns the user's identity shared across platforms
+func (c *Config) identity(p Platform, userID string) string {
+	if c.Identity != nil {
+		if id := c.Identity(p, userID); id != "" {
+			return id
+		}
+	}
+
+	return fmt.Sprintf("%d:%s", p, userID)
+}
+
+// key returns the bucket the request counts towards. Command limits are
+// kept apart from the config's.
+func (l RateLimit) key(req *Request, cmd string, c *Config) string {
+	owner := "config"
+	if cmd != "" {
+		owner = "command:" + cmd
+	}
+
+	switch l.Scope {
+	case RateScopeUser:
+		return fmt.Sprintf("%s:user:%s", owner, c.identity(req.Target.Platform, req.UserID))
+	case RateScopeChat:
+		return fmt.Sprintf("%s:chat:%d:%s", owner, req.Target.Platform, req.Target.ChatID)
+	case RateScopeCommand:
+		return fmt.Sprintf("%s:command:%s", owner, req.Command)
+	default:
+		return owner + ":global"
+	}
+}
+
+// take counts the request towards the rate limits of the config and command.
+// Nothing is counted unless every limit allows the request, in which case the
+// time until it would be is returned.
+func (c *Config) take(req *Request, cmd *Command) time.Duration {
+	type use struct {
+		key   string
+		limit RateLimit
+	}
+
+	var uses []use
+	for _, l := range c.RateLimits {
+		uses = append(uses, use{l.key(req, "", c), l})
+	}
+
+	if cmd != nil {
+		for _, l := range cmd.RateLimits {
+			uses = append(uses, use{l.key(req, cmd.name(), c), l})
+		}
+	}
+
+	if len(uses) == 0 {
+		return 0
+	}
+
+	c.limiter.mu.Lock()
+	defer c.limiter.mu.Unlock()
+
+	now := time.Now()
+	c.sweepBuckets(now)
+
+	buckets := make([]*rateBucket, len(uses))
+
+	var wait time.Duration
+	for i, u := range uses {
+		b := c.bucket(u.key, u.limit, now)
+		buckets[i] = b
+
+		if b.Tokens < 1 {
+			wait = max(wait, time.Duration((1-b.Tokens)*float64(u.limit.Every)))
+		}
+	}
+
+	if wait > 0 {
+		c.metric().rateLimited.WithLabelValues(platformName(req.Target.Platform), req.Command).Inc()
+		return wait
+	}
+
+	for i, u := range uses {
+		buckets[i].Tokens--
+		c.saveBucket(u.key)
+	}
+
+	return 0
+}
+
+// bucket returns the bucket refilled up to now. The caller must hold the lock.
+func (c *Config) bucket(key string, l RateLimit, now time.Time) *rateBucket {
+	if c.limiter.buckets == nil {
+		c.limiter.buckets = make(map[string]*rateBucket)
+	}
+
+	b, ok := c.limiter.buckets[key]
+	if !ok && c.PersistRateLimits {
+		var stored rateBucket
+		if err := c.ReadCache(rateCacheKey(key), &stored); err == nil {
+			b, ok = &stored, true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			c.logger().Error("Failed to read rate limit", "error", err)
+		}
+	}
+
+	if !ok {
+		b = &rateBucket{Tokens: float64(l.Burst), Updated: now}
+	}
+
+	if l.Every > 0 {
+		b.Tokens += float64(now.Sub(b.Updated)) / float64(l.Every)
+	}
+	b.Tokens = math.Min(b.Tokens, float64(l.Burst))
+	b.Updated = now
+	b.limit = l
+
+	c.limiter.buckets[key] = b
+	return b
+}
+
+// full reports whether the bucket has refilled by now, making it no
+// different from a new one
+func (b *rateBucket) full(now time.Time) bool {
+	tokens := b.Tokens
+	if b.limit.Every > 0 {
+		tokens += float64(now.Sub(b.Updated)) / float64(b.limit.Every)
+	}
+
+	return tokens >= float64(b.limit.Burst)
+}
+
+// sweepBuckets evicts buckets that have refilled, along with persisted ones
+// left unused, as they can be read again. The caller must hold the lock.
+func (c *Config) sweepBuckets(now time.Time) {
+	l := &c.limiter
+	if now.Sub(l.swept) < rateSweepInterval {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		switch {
+		case b.full(now):
+			delete(l.buckets, key)
+			c.saveBucket(key)
+
+		case c.PersistRateLimits && !l.dirty[key] && now.Sub(b.Updated) > rateIdleTimeout:
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// saveBucket schedules the bucket to be persisted if enabled, or removed if
+// it was evicted. Changes are written in batches. The caller must hold the
+// lock.
+func (c *Config) saveBucket(key string) {
+	if !c.PersistRateLimits {
+		return
+	}
+
+	l := &c.limiter
+	if l.dirty == nil {
+		l.dirty = make(map[string]bool)
+	}
+	l.dirty[key] = true
+
+	if l.flush == nil {
+		l.flush = time.AfterFunc(rateFlushDelay, c.flushBuckets)
+	}
+}
+
+// flushBuckets persists the buckets changed since the last flush, removing
+// those evicted since
+func (c *Config) flushBuckets() {
+	l := &c.limiter
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	changed := make(map[string]*rateBucket, len(l.dirty))
+	for key := range l.dirty {
+		if b, ok := l.buckets[key]; ok {
+			cp := *b
+			changed[key] = &cp
+		} else {
+			changed[key] = nil
+		}
+	}
+	l.dirty = nil
+	if l.flush != nil {
+		l.flush.Stop()
+		l.flush = nil
+	}
+	l.mu.Unlock()
+
+	for key, b := range changed {
+		if b == nil {
+			path := filepath.Join(c.CacheDirectory, rateCacheKey(key)+".json")
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				c.logger().Error("Failed to remove rate limit", "error", err)
+			}
+
+			continue
+		}
+
+		data, err := json.Marshal(b)
+		if err != nil {
+			c.logger().Error("Failed to marshal rate limit", "error", err)
+			continue
+		}
+
+		if err := c.WriteCache(rateCacheKey(key), data); err != nil {
+			c.logger().Error("Failed to write rate limit", "error", err)
+		}
+	}
+}
+
+func rateCacheKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return "ratelimit-" + hex.EncodeToString(sum[:])
+}
+
+// cooldown explains how long to wait before trying again
+func cooldown(wait time.Duration) string {
+	return fmt.Sprintf("⏳ Slow down! Try again in %s", max(wait.Round(time.Second), time.Second))
+}
+
+// rateLimitMiddleware refuses requests exceeding the rate limits of the config
+// and command
+func (c *Config) rateLimitMiddleware(cmd *Command) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) *Message {
+			// Callbacks are checked before running, as their cooldown must not
+			// replace the message they belong to, and continuations were
+			// already charged when the command was invoked
+			if req.Callback || req.continuation {
+				return next(req)
+			}
+
+			if wait := c.take(req, cmd); wait > 0 {
+				msg := &Message{Title: cooldown(wait)}
+				if req.Target.Platform == PlatformDiscord {
+					msg.Visibility = VisibilityEphemeral
+				}
+
+				return msg
+			}
+
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package crossbot
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValidateRateLimits(t *testing.T) {
+	tests := []struct {
+		limits  []RateLimit
+		wantErr bool
+	}{
+		{nil, false},
+		{[]RateLimit{{Burst: 1, Every: time.Second}}, false},
+		{[]RateLimit{{Burst: 3, Every: time.Minute}, {Scope: RateScopeGlobal, Burst: 10, Every: time.Hour}}, false},
+		{[]RateLimit{{Burst: 0, Every: time.Second}}, true},
+		{[]RateLimit{{Burst: -1, Every: time.Second}}, true},
+		{[]RateLimit{{Burst: 1}}, true},
+		{[]RateLimit{{Burst: 1, Every: -time.Second}}, true},
+		{[]RateLimit{{Burst: 1, Every: time.Second}, {Burst: 1}}, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateRateLimits(tt.limits); (err != nil) != tt.wantErr {
+			t.Errorf("validateRateLimits(%v) = %v, want error %t", tt.limits, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := RateLimit{Burst: 4, Every: 10 * time.Second}
+
+	tests := []struct {
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 0, 0},
+		{0, 5 * time.Second, 0.5},
+		{0, 10 * time.Second, 1},
+		{1.5, 15 * time.Second, 3},
+		{0, 40 * time.Second, 4},
+		{2, time.Hour, 4},
+		{4, time.Second, 4},
+	}
+
+	for _, tt := range tests {
+		c := &Config{}
+		c.limiter.buckets = map[string]*rateBucket{"key": {Tokens: tt.tokens, Updated: start}}
+
+		b := c.bucket("key", limit, start.Add(tt.elapsed))
+		if math.Abs(b.Tokens-tt.want) > 1e-9 {
+			t.Errorf("bucket with %v tokens after %s has %v tokens, want %v", tt.tokens, tt.elapsed, b.Tokens, tt.want)
+		}
+
+		if !b.Updated.Equal(start.Add(tt.elapsed)) {
+			t.Errorf("bucket with %v tokens after %s was updated at %s", tt.tokens, tt.elapsed, b.Updated)
+		}
+	}
+}
+
+func TestBucketNew(t *testing.T) {
+	c := &Config{}
+	now := time.Now()
+
+	b := c.bucket("key", RateLimit{Burst: 3, Every: time.Minute}, now)
+	if b.Tokens != 3 {
+		t.Errorf("new bucket has %v tokens, want 3", b.Tokens)
+	}
+}
+
+func TestBucketFull(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := RateLimit{Burst: 2, Every: time.Minute}
+
+	tests := []struct {
+		tokens  float64
+		elapsed time.Duration
+		want    bool
+	}{
+		{2, 0, true},
+		{1, 0, false},
+		{1, 59 * time.Second, false},
+		{1, time.Minute, true},
+		{0, 90 * time.Second, false},
+		{0, 2 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		b := &rateBucket{Tokens: tt.tokens, Updated: start, limit: limit}
+		if got := b.full(start.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("bucket with %v tokens full after %s = %t, want %t", tt.tokens, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   []RateLimit
+		command  []RateLimit
+		requests int
+		allowed  int
+	}{
+		{"no limits", nil, nil, 5, 5},
+		{"config burst", []RateLimit{{Burst: 2, Every: time.Hour}}, nil, 5, 2},
+		{"command burst", nil, []RateLimit{{Burst: 3, Every: time.Hour}}, 5, 3},
+		{"strictest wins", []RateLimit{{Burst: 4, Every: time.Hour}}, []RateLimit{{Burst: 1, Every: time.Hour}}, 5, 1},
+	}
+
+	for _, tt := range tests {
+		c := &Config{RateLimits: tt.config}
+		cmd := &Command{Text: TextCommand{Aliases: []string{"test"}}, RateLimits: tt.command}
+		req := &Request{Target: Target{Platform: PlatformDiscord, ChatID: "chat"}, UserID: "user", Command: "test"}
+
+		var allowed int
+		for range tt.requests {
+			if c.take(req, cmd) == 0 {
+				allowed++
+			}
+		}
+
+		if allowed != tt.allowed {
+			t.Errorf("%s: %d of %d requests allowed, want %d", tt.name, allowed, tt.requests, tt.allowed)
+		}
+	}
+}
+
+func TestTakeWait(t *testing.T) {
+	c := &Config{RateLimits: []RateLimit{{Burst: 1, Every: time.Minute}}}
+	req := &Request{Target: Target{Platform: PlatformTelegram, ChatID: "chat"}, UserID: "user"}
+
+	if wait := c.take(req, nil); wait != 0 {
+		t.Fatalf("first request waits %s, want 0", wait)
+	}
+
+	// Refused requests are not charged, so the wait never exceeds one interval
+	for range 3 {
+		if wait := c.take(req, nil); wait <= 0 || wait > time.Minute {
+			t.Errorf("refused request waits %s, want up to %s", wait, time.Minute)
+		}
+	}
+}
+
+func TestTakeScopes(t *testing.T) {
+	limit := func(scope RateScope) []RateLimit {
+		return []RateLimit{{Scope: scope, Burst: 1, Every: time.Hour}}
+	}
+
+	alice := &Request{Target: Target{Platform: PlatformDiscord, ChatID: "one"}, UserID: "alice", Command: "a"}
+	bob := &Request{Target: Target{Platform: PlatformDiscord, ChatID: "one"}, UserID: "bob", Command: "a"}
+	elsewhere := &Request{Target: Target{Platform: PlatformDiscord, ChatID: "two"}, UserID: "alice", Command: "b"}
+
+	tests := []struct {
+		scope  RateScope
+		second *Request
+		shared bool
+	}{
+		{RateScopeUser, bob, false},
+		{RateScopeUser, elsewhere, true},
+		{RateScopeChat, bob, true},
+		{RateScopeChat, elsewhere, false},
+		{RateScopeCommand, bob, true},
+		{RateScopeCommand, elsewhere, false},
+		{RateScopeGlobal, bob, true},
+		{RateScopeGlobal, elsewhere, true},
+	}
+
+	for _, tt := range tests {
+		c := &Config{RateLimits: limit(tt.scope)}
+		c.take(alice, nil)
+
+		if shared := c.take(tt.second, nil) > 0; shared != tt.shared {
+			t.Errorf("scope %d: bucket shared with %s in %s = %t, want %t", tt.scope, tt.second.UserID, tt.second.Target.ChatID, shared, tt.shared)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		refused bool
+	}{
+		{"invocation", Request{}, true},
+		{"callback", Request{Callback: true}, false},
+		{"continuation", Request{continuation: true}, false},
+	}
+
+	for _, tt := range tests {
+		c := &Config{RateLimits: []RateLimit{{Burst: 1, Every: time.Hour}}}
+		ran := false
+		h := c.rateLimitMiddleware(nil)(func(*Request) *Message {
+			ran = true
+			return &Message{}
+		})
+
+		req := tt.req
+		req.Target = Target{Platform: PlatformDiscord, ChatID: "chat"}
+		req.UserID = "user"
+		c.take(&req, nil)
+
+		h(&req)
+		if ran == tt.refused {
+			t.Errorf("%s: handler ran = %t after the burst was used, want %t", tt.name, ran, !tt.refused)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"runtime/debug"
+	"time"
+)
+
+// defaultHandlerTimeout is how long handlers may run if neither the config nor
+// the command specify a timeout
+const defaultHandlerTimeout = time.Minute
+
+// internalError is the response to handlers that panicked
+func internalError() *Message {
+	return &Message{
+		Title:       "⚠️ Something went wrong",
+		Description: "An internal error occurred while running the command.",
+		notice:      true,
+	}
+}
+
+// timedOut is the response to handlers that did not finish in time
+func timedOut() *Message {
+	return &Message{
+		Title:       "⌛ The command took too long",
+		Description: "Please try again later.",
+		notice:      true,
+	}
+}
+
+// shuttingDown is the response to handlers cancelled as the bot stops
+func shuttingDown() *Message {
+	return &Message{
+		Title:       "🔌 The bot is restarting",
+		Description: "Please try again in a moment.",
+		notice:      true,
+	}
+}
+
+// recoverPanic logs a panic along with its stack instead of crashing the bot.
+// It must be deferred directly.
+func (c *Config) recoverPanic(where string) {
+	if r := recover(); r != nil {
+		c.logPanic(r, slog.String("in", where))
+	}
+}
+
+// logPanic logs the recovered value along with the current stack, which
+// still includes the panicking frames when called from a deferred function
+func (c *Config) logPanic(r any, attrs ...any) {
+	attrs = append(attrs, slog.Any("panic", r), slog.String("stack", string(debug.Stack())))
+	c.logger().Error("Recovered from panic", attrs...)
+}
+
+// handlerTimeout returns how long the command's handlers may run, or 0 if
+// they may run forever
+func (c *Config) handlerTimeout(cmd *Command) time.Duration {
+	timeout := c.HandlerTimeout
+	if cmd != nil && cmd.Timeout != 0 {
+		timeout = cmd.Timeout
+	}
+
+	switch {
+	case timeout == 0:
+		return defaultHandlerTimeout
+	case timeout < 0:
+		return 0
+	default:
+		return timeout
+	}
+}
+
+// contextOutcome returns the outcome of a request whose context is done
+func contextOutcome(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return outcomeTimeout
+	}
+
+	return outcomeCanceled
+}
+
+// guard runs the handler with a timeout on the request's context, responding
+// with an internal error if it panics. Handlers ignoring their context are
+// abandoned once they time out or the bot stops, which are told apart.
+func (c *Config) guard(cmd *Command, h Handler) Handler {
+	return func(req *Request) *Message {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+		if timeout := c.handlerTimeout(cmd); timeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		} else {
+			ctx, cancel = context.WithCancel(req.Context())
+		}
+		defer cancel()
+
+		// The handler gets its own copy, as it may outlive the call
+		r := *req
+		r.ctx = ctx
+		r.Fields, r.Files = maps.Clone(req.Fields), maps.Clone(req.Files)
+
+		type result struct {
+			msg     *Message
+			outcome string
+		}
+
+		start := time.Now()
+		done := make(chan result, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					c.logPanic(p, slog.String("command", r.Command))
+					done <- result{internalError(), outcomePanic}
+				}
+			}()
+
+			done <- result{h(&r), outcomeOK}
+		}()
+
+		select {
+		case res := <-done:
+			c.logRequest(req, start, res.outcome)
+			c.recordRequest(req, start, res.outcome)
+			return res.msg
+
+		case <-ctx.Done():
+			outcome := contextOutcome(ctx)
+			c.logRequest(req, start, outcome)
+			c.recordRequest(req, start, outcome)
+
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return shuttingDown()
+			}
+
+			return timedOut()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package crossbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot/models"
+)
+
+// remindJobHandler is the job handler name used by the built-in /remind command
+const remindJobHandler = "crossbot.remind"
+
+// RemindCommand returns a built-in "/remind <duration> <message>" command that
+// schedules a one-off job replying in the same chat (i.e. "/remind 1h30m
+// stand up"). It registers its job handler on the config.
+func (c *Config) RemindCommand() *Command {
+	if c.JobHandlers == nil {
+		c.JobHandlers = make(map[string]JobHandler)
+	}
+
+	c.JobHandlers[remindJobHandler] = func(fields map[string]string) *Message {
+		return &Message{
+			Title:       "⏰ Reminder",
+			Description: fields["message"],
+			Footer:      Footer{Text: fmt.Sprintf("Requested by %s", fields["user"])},
+		}
+	}
+
+	whole := ""
+	return &Command{
+		Text: TextCommand{
+			Aliases:       []string{"remind"},
+			SplitFieldsOn: &whole,
+		},
+		Telegram: TelegramCommand{
+			BotComand: models.BotCommand{
+				Command:     "remind",
+				Description: "Set a reminder, i.e. /remind 10m take a break",
+			},
+		},
+		Discord: DiscordCommand{
+			ApplicationCommand: discordgo.ApplicationCommand{
+				Name:        "remind",
+				Description: "Set a reminder",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "in",
+						Description: "When to remind you, i.e. 10m or 1h30m",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message",
+						Description: "What to remind you about",
+						Required:    true,
+					},
+				},
+			},
+		},
+		Handler: c.remind,
+	}
+}
+
+func (c *Config) remind(fields map[string]string) *Message {
+	in, message := fields["in"], fields["message"]
+
+	// Text commands receive the entire message unparsed
+	if text, ok := fields[""]; ok {
+		parts := strings.SplitN(strings.TrimSpace(text), " ", 2)
+		in = parts[0]
+		if len(parts) > 1 {
+			message = strings.TrimSpace(parts[1])
+		}
+	}
+
+	d, err := time.ParseDuration(in)
+	if err != nil || d <= 0 || message == "" {
+		return &Message{
+			Title:           "Usage: /remind <duration> <message>",
+			RichDescription: RichText{Plain("Example: "), Code("/remind 1h30m stand up")},
+		}
+	}
+
+	target, err := FieldsTarget(fields)
+	if err != nil {
+		return &Message{Title: "Failed to set reminder", Description: err.Error()}
+	}
+
+	at := time.Now().Add(d)
+	job := Job{
+		ID:      c.NewCacheID(map[string]string{"remind": fmt.Sprint(at.UnixNano()), "user": fields["user"]}),
+		At:      at,
+		Targets: []Target{target},
+		Handler: remindJobHandler,
+		Fields:  map[string]string{"message": message, "user": fields["user"]},
+	}
+
+	if err := c.Schedule(job); err != nil {
+		return &Message{Title: "Failed to set reminder", Description: err.Error()}
+	}
+
+	return &Message{Title: fmt.Sprintf("I'll remind you in %s", d)}
+}
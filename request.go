@@ -0,0 +1,167 @@
+package crossbot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Handler is a function ran once a command is called, receiving the whole
+// request. Middlewares wrap handlers of this type.
+type Handler func(req *Request) *Message
+
+// Request is a single invocation of a command
+type Request struct {
+	// Chat the command was invoked in
+	Target Target
+
+	// Name of the invoking user
+	User string
+
+	// Platform-specific ID of the invoking user
+	UserID string
+
+	// Arguments and options specified by the user. The "user", "platform",
+	// "chat" and "thread" keys are always populated, as is "user_id" when the
+	// user's ID is known.
+	Fields map[string]string
+
+	// Files attached by the user, keyed by their attachment argument name
+	Files map[string]*File
+
+	// Name of the command the request belongs to, if known
+	Command string
+
+	// Whether the request comes from a button or select menu rather than the
+	// command itself
+	Callback bool
+
+	// Set on requests continuing an earlier invocation of the command, such
+	// as conversation answers, which are not charged to its rate limits again
+	continuation bool
+
+	ctx context.Context
+
+	// Function showing an intermediate message, if supported
+	progress func(ctx context.Context, msg *Message) error
+
+	// Membership and guild sent along with Discord interactions, sparing API
+	// calls when checking access
+	discordMember  *discordgo.Member
+	discordGuildID *string
+}
+
+// File is a file attached by the user. Its content is only downloaded from the
+// platform once opened.
+type File struct {
+	Name        string
+	ContentType string
+
+	// Size in bytes, if reported by the platform
+	Size int64
+
+	// Function returning a URL the file can be downloaded from. On Telegram
+	// this requires an API call and the URL contains the bot token, so it is
+	// resolved lazily and must not be shared.
+	url func(ctx context.Context) (string, error)
+}
+
+// newRequest creates a request for a user in a chat with its base fields
+func newRequest(ctx context.Context, target Target, user, userID string) *Request {
+	req := &Request{
+		Target: target,
+		User:   user,
+		UserID: userID,
+		Fields: make(map[string]string),
+		Files:  make(map[string]*File),
+		ctx:    ctx,
+	}
+
+	req.Fields["user"] = user
+	if userID != "" {
+		req.Fields["user_id"] = userID
+	}
+	target.setFields(req.Fields)
+
+	return req
+}
+
+// Context returns the request's context, which is cancelled once the bot
+// stops or the command is abandoned. Requests created through Run are only
+// cancelled when abandoned.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+// Progress shows an intermediate message (i.e. "Downloading… 40%") while the
+// handler runs. Each call replaces the previous message, and the handler's
+// result replaces the last one. Requests that cannot show progress, such as
+// those created through Run, ignore it.
+func (r *Request) Progress(msg *Message) error {
+	if r.progress == nil {
+		return nil
+	}
+
+	return r.progress(r.Context(), msg)
+}
+
+// setDiscordInteraction records the membership and guild sent along with the
+// interaction
+func (r *Request) setDiscordInteraction(i *discordgo.Interaction) {
+	r.discordMember = i.Member
+	r.discordGuildID = &i.GuildID
+}
+
+// File returns the file attached under the attachment argument, or nil if
+// none was attached
+func (r *Request) File(name string) *File {
+	return r.Files[name]
+}
+
+// Open downloads the file's content. The caller must close the reader.
+func (f *File) Open(ctx context.Context) (io.ReadCloser, error) {
+	u, err := f.url(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve file '%s': %w", f.Name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file '%s': %w", f.Name, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download file '%s': %s", f.Name, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// Bytes downloads the file's entire content
+func (f *File) Bytes(ctx context.Context) ([]byte, error) {
+	r, err := f.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%s': %w", f.Name, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,194 @@
+package crossbot
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// RichText is formatted text made of spans, rendered to each platform's
+// markup with any user-supplied text escaped
+type RichText []Span
+
+// Span is a run of text sharing a single style
+type Span struct {
+	Style Style
+	Text  string
+
+	// Link URL, or the user ID for mentions
+	URL string
+
+	// Code block language used for syntax highlighting
+	Language string
+}
+
+type Style uint8
+
+const (
+	StylePlain Style = iota
+	StyleBold
+	StyleItalic
+	StyleCode
+	StyleCodeBlock
+	StyleLink
+	StyleMention
+	StyleSpoiler
+)
+
+func Plain(text string) Span  { return Span{Style: StylePlain, Text: text} }
+func Bold(text string) Span   { return Span{Style: StyleBold, Text: text} }
+func Italic(text string) Span { return Span{Style: StyleItalic, Text: text} }
+func Code(text string) Span   { return Span{Style: StyleCode, Text: text} }
+
+func CodeBlock(language, text string) Span {
+	return Span{Style: StyleCodeBlock, Text: text, Language: language}
+}
+
+func Link(text, url string) Span { return Span{Style: StyleLink, Text: text, URL: url} }
+
+// Mention references a user by their platform user ID. The name is only shown
+// on Telegram, as Discord displays the user's current name itself.
+func Mention(userID, name string) Span {
+	return Span{Style: StyleMention, Text: name, URL: userID}
+}
+
+func Spoiler(text string) Span { return Span{Style: StyleSpoiler, Text: text} }
+
+// String returns the text without any formatting
+func (t RichText) String() string {
+	var sb strings.Builder
+	for _, s := range t {
+		sb.WriteString(s.Text)
+	}
+
+	return sb.String()
+}
+
+// Discord renders the text as Discord markdown
+func (t RichText) Discord() string {
+	var sb strings.Builder
+	for _, s := range t {
+		text := escapeDiscord(s.Text)
+		switch s.Style {
+		case StyleBold:
+			fmt.Fprintf(&sb, "**%s**", text)
+		case StyleItalic:
+			fmt.Fprintf(&sb, "_%s_", text)
+		case StyleCode:
+			// Double backticks allow single backticks inside the code span
+			if strings.Contains(s.Text, "`") {
+				fmt.Fprintf(&sb, "`` %s ``", s.Text)
+			} else {
+				fmt.Fprintf(&sb, "`%s`", s.Text)
+			}
+		case StyleCodeBlock:
+			// A zero-width space prevents the code from closing the block early
+			code := strings.ReplaceAll(s.Text, "```", "`\u200b``")
+			fmt.Fprintf(&sb, "```%s\n%s\n```", s.Language, code)
+		case StyleLink:
+			fmt.Fprintf(&sb, "[%s](%s)", text, s.URL)
+		case StyleMention:
+			fmt.Fprintf(&sb, "<@%s>", s.URL)
+		case StyleSpoiler:
+			fmt.Fprintf(&sb, "||%s||", text)
+		default:
+			sb.WriteString(text)
+		}
+	}
+
+	return sb.String()
+}
+
+// TelegramMarkdown renders the text as Telegram MarkdownV2
+func (t RichText) TelegramMarkdown() string {
+	var sb strings.Builder
+	for _, s := range t {
+		text := escapeTelegramMarkdown(s.Text)
+		switch s.Style {
+		case StyleBold:
+			fmt.Fprintf(&sb, "*%s*", text)
+		case StyleItalic:
+			fmt.Fprintf(&sb, "_%s_", text)
+		case StyleCode:
+			fmt.Fprintf(&sb, "`%s`", escapeTelegramCode(s.Text))
+		case StyleCodeBlock:
+			fmt.Fprintf(&sb, "```%s\n%s\n```", s.Language, escapeTelegramCode(s.Text))
+		case StyleLink:
+			fmt.Fprintf(&sb, "[%s](%s)", text, escapeTelegramURL(s.URL))
+		case StyleMention:
+			fmt.Fprintf(&sb, "[%s](tg://user?id=%s)", text, escapeTelegramURL(s.URL))
+		case StyleSpoiler:
+			fmt.Fprintf(&sb, "||%s||", text)
+		default:
+			sb.WriteString(text)
+		}
+	}
+
+	return sb.String()
+}
+
+// TelegramHTML renders the text as Telegram HTML
+func (t RichText) TelegramHTML() string {
+	var sb strings.Builder
+	for _, s := range t {
+		text := html.EscapeString(s.Text)
+		switch s.Style {
+		case StyleBold:
+			fmt.Fprintf(&sb, "<b>%s</b>", text)
+		case StyleItalic:
+			fmt.Fprintf(&sb, "<i>%s</i>", text)
+		case StyleCode:
+			fmt.Fprintf(&sb, "<code>%s</code>", text)
+		case StyleCodeBlock:
+			if s.Language != "" {
+				fmt.Fprintf(&sb, `<pre><code class="language-%s">%s</code></pre>`, html.EscapeString(s.Language), text)
+			} else {
+				fmt.Fprintf(&sb, "<pre>%s</pre>", text)
+			}
+		case StyleLink:
+			fmt.Fprintf(&sb, `<a href="%s">%s</a>`, html.EscapeString(s.URL), text)
+		case StyleMention:
+			fmt.Fprintf(&sb, `<a href="tg://user?id=%s">%s</a>`, html.EscapeString(s.URL), text)
+		case StyleSpoiler:
+			fmt.Fprintf(&sb, "<tg-spoiler>%s</tg-spoiler>", text)
+		default:
+			sb.WriteString(text)
+		}
+	}
+
+	return sb.String()
+}
+
+var (
+	discordEscaper = strings.NewReplacer(
+		`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `~`, `\~`, `|`, `\|`,
+		`[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`, `#`, `\#`, `-`, `\-`,
+	)
+
+	telegramMarkdownEscaper = strings.NewReplacer(
+		`\`, `\\`, `_`, `\_`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`,
+		`~`, `\~`, "`", "\\`", `>`, `\>`, `#`, `\#`, `+`, `\+`, `-`, `\-`, `=`, `\=`,
+		`|`, `\|`, `{`, `\{`, `}`, `\}`, `.`, `\.`, `!`, `\!`,
+	)
+
+	telegramCodeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+	telegramURLEscaper  = strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+)
+
+// escapeDiscord escapes Discord markdown so the text is shown as-is
+func escapeDiscord(s string) string {
+	return discordEscaper.Replace(s)
+}
+
+// escapeTelegramMarkdown escapes every MarkdownV2 special character
+func escapeTelegramMarkdown(s string) string {
+	return telegramMarkdownEscaper.Replace(s)
+}
+
+func escapeTelegramCode(s string) string {
+	return telegramCodeEscaper.Replace(s)
+}
+
+func escapeTelegramURL(s string) string {
+	return telegramURLEscaper.Replace(s)
+}
@@ -1,6 +1,7 @@
 package crossbot
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -9,40 +10,98 @@ import (
 
 // Run validates & runs the specified command
 func (c *Config) Run(cmd *Command, user, msg, command string, platform Platform) (text string, markup models.ReplyMarkup) {
+	return c.RunIn(cmd, user, msg, command, Target{Platform: platform})
+}
+
+// RunIn validates & runs the specified command as if invoked in the chat,
+// which commands responding later (i.e. /remind) require
+func (c *Config) RunIn(cmd *Command, user, msg, command string, target Target) (text string, markup models.ReplyMarkup) {
+	res := c.run(cmd, newRequest(context.Background(), target, user, ""), msg, command)
+	if res == nil {
+		return "", nil
+	}
+
+	res, _ = c.degrade(res, PlatformTelegram)
+	return res.Telegram()
+}
+
+// run parses the text command's fields into the request, then validates &
+// runs the command, returning its message
+func (c *Config) run(cmd *Command, req *Request, msg, command string) *Message {
+	_, span := c.startSpan(req.Context(), "parse", req.Target)
 	msg = strings.TrimPrefix(msg, "/"+command)
-	fields := c.parseFields(msg, cmd.Text)
-	fields["user"] = user
-	fields["platform"] = fmt.Sprint(platform)
+	for k, v := range c.parseFields(msg, cmd.Text) {
+		req.Fields[k] = v
+	}
+	span.End()
 
 	for _, a := range cmd.Text.Arguments {
-		if _, ok := fields[a]; ok {
-			continue
+		if _, ok := req.Fields[a]; !ok {
+			return usage(cmd)
 		}
+	}
 
-		var parts []string
-
-		if len(cmd.Text.Arguments) > 0 {
-			required := fmt.Sprintf("Required: %s", strings.Join(cmd.Text.Arguments, " | "))
-			parts = append(parts, required)
+	for _, a := range cmd.Text.Attachments {
+		if _, ok := req.Files[a]; !ok {
+			return usage(cmd)
 		}
+	}
 
-		if len(cmd.Text.Options) > 0 {
-			optional := fmt.Sprintf("Optional: %s", strings.Join(cmd.Text.Options, " | "))
-			parts = append(parts, optional)
-		}
+	return c.handle(cmd, req)
+}
 
-		exampleParts := []string{fmt.Sprintf("/%s", cmd.Text.Aliases[0])}
-		exampleParts = append(exampleParts, cmd.Text.Arguments...)
-		for _, opt := range cmd.Text.Options {
-			exampleParts = append(exampleParts, fmt.Sprintf("--%s=value", opt))
-		}
+// usage explains how to call a text command
+func usage(cmd *Command) *Message {
+	var parts []string
+
+	if len(cmd.Text.Arguments) > 0 {
+		required := fmt.Sprintf("Required: %s", strings.Join(cmd.Text.Arguments, " | "))
+		parts = append(parts, required)
+	}
+
+	if len(cmd.Text.Attachments) > 0 {
+		attachments := fmt.Sprintf("Attachments: %s", strings.Join(cmd.Text.Attachments, " | "))
+		parts = append(parts, attachments)
+	}
+
+	if len(cmd.Text.Options) > 0 {
+		optional := fmt.Sprintf("Optional: %s", strings.Join(cmd.Text.Options, " | "))
+		parts = append(parts, optional)
+	}
+
+	exampleParts := []string{fmt.Sprintf("/%s", cmd.Text.Aliases[0])}
+	exampleParts = append(exampleParts, cmd.Text.Arguments...)
+	for _, opt := range cmd.Text.Options {
+		exampleParts = append(exampleParts, fmt.Sprintf("--%s=value", opt))
+	}
 
-		example := fmt.Sprintf("Example: `%s`", strings.Join(exampleParts, " "))
-		parts = append(parts, example)
+	parts = append(parts, "Example: ")
+	return &Message{RichDescription: RichText{
+		Plain(strings.Join(parts, "\n")),
+		Code(strings.Join(exampleParts, " ")),
+	}}
+}
+
+// handle runs the command's handler, or starts its conversation, through the
+// middlewares, recovering from panics and enforcing its timeout
+func (c *Config) handle(cmd *Command, req *Request) *Message {
+	req.Command = cmd.name()
 
-		msg := strings.Join(parts, "\n")
-		return msg, nil
+	h := cmd.RequestHandler
+	if h == nil {
+		h = func(req *Request) *Message {
+			return cmd.Handler(req.Fields)
+		}
 	}
 
-	return cmd.Handler(fields).Telegram()
+	if cmd.Conversation != nil {
+		h = func(req *Request) *Message {
+			return c.startConversation(cmd, req)
+		}
+	}
+
+	msg := c.guard(cmd, c.chain(cmd, h))(req)
+	msg.setOrigin(cmd)
+
+	return msg
 }
@@ -0,0 +1,365 @@
+package crossbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// jobsCacheKey is the cache key that job definitions are persisted under
+	jobsCacheKey = "jobs"
+
+	// jobRetryDelay is how long one-off jobs wait before retrying targets whose
+	// platform was not connected yet, doubling each attempt up to
+	// jobRetryDelayMax
+	jobRetryDelay    = time.Minute
+	jobRetryDelayMax = time.Hour
+
+	// jobRetryLimit is how many times a one-off job is retried before its
+	// remaining targets are given up on
+	jobRetryLimit = 10
+)
+
+// JobHandler builds the message posted when a job runs
+type JobHandler func(fields map[string]string) *Message
+
+// Job is a scheduled message delivered to one or more targets. Jobs are
+// persisted in the cache directory, so they are restored after a restart.
+type Job struct {
+	// Unique job identifier. Scheduling a job with an existing ID replaces it.
+	ID string
+
+	// Cron expression ("minute hour day-of-month month day-of-week") for
+	// recurring jobs. Descriptors such as "@daily" are also accepted.
+	Cron string
+
+	// Time to run a one-off job at. Ignored if Cron is set.
+	At time.Time
+
+	// IANA time zone name the cron expression is evaluated in (i.e.
+	// "Europe/Berlin"). Defaults to UTC.
+	Location string
+
+	// Chats that the job's message will be delivered to
+	Targets []Target
+
+	// Name of the handler in Config.JobHandlers that builds the message.
+	// Handlers are referenced by name since functions cannot be persisted.
+	Handler string
+
+	// Fields passed to the handler
+	Fields map[string]string
+}
+
+type scheduler struct {
+	mu     sync.Mutex
+	jobs   map[string]Job
+	timers map[string]*time.Timer
+
+	// Number of times one-off jobs were retried
+	retries map[string]int
+}
+
+// Schedule registers a job and persists it to the cache
+func (c *Config) Schedule(job Job) error {
+	s, err := c.jobScheduler()
+	if err != nil {
+		return err
+	}
+
+	if err := c.validateJob(job); err != nil {
+		return fmt.Errorf("invalid job '%s': %w", job.ID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = job
+	delete(s.retries, job.ID)
+	c.armJob(s, job)
+
+	return c.saveJobs(s)
+}
+
+// Unschedule stops and removes a job
+func (c *Config) Unschedule(id string) error {
+	s, err := c.jobScheduler()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("job '%s' not found", id)
+	}
+
+	c.removeJob(s, id)
+	return c.saveJobs(s)
+}
+
+// Jobs returns all scheduled jobs, sorted by ID
+func (c *Config) Jobs() ([]Job, error) {
+	s, err := c.jobScheduler()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// jobScheduler lazily creates the scheduler, restoring any persisted jobs
+func (c *Config) jobScheduler() (*scheduler, error) {
+	var err error
+	c.schedulerOnce.Do(func() {
+		if err = c.Validate(); err != nil {
+			err = fmt.Errorf("invalid configuration: %w", err)
+			return
+		}
+
+		s := &scheduler{
+			jobs:    make(map[string]Job),
+			timers:  make(map[string]*time.Timer),
+			retries: make(map[string]int),
+		}
+
+		var jobs []Job
+		if rerr := c.ReadCache(jobsCacheKey, &jobs); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			c.logger().Error("Failed to restore scheduled jobs", "error", rerr)
+		}
+
+		s.mu.Lock()
+		for _, job := range jobs {
+			if err := c.validateJob(job); err != nil {
+				c.logger().Warn("Skipping invalid scheduled job", "job", job.ID, "error", err)
+				continue
+			}
+
+			s.jobs[job.ID] = job
+			c.armJob(s, job)
+		}
+		s.mu.Unlock()
+
+		c.scheduler = s
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.scheduler == nil {
+		return nil, errors.New("scheduler unavailable")
+	}
+
+	return c.scheduler, nil
+}
+
+func (c *Config) validateJob(job Job) error {
+	switch {
+	case job.ID == "":
+		return errors.New("id must be specified")
+	case job.Cron == "" && job.At.IsZero():
+		return errors.New("either Cron or At must be specified")
+	case len(job.Targets) == 0:
+		return errors.New("no targets specified")
+	case c.JobHandlers[job.Handler] == nil:
+		return fmt.Errorf("handler '%s' not registered", job.Handler)
+	default:
+	}
+
+	if job.Cron != "" {
+		if _, err := parseCron(job.Cron); err != nil {
+			return err
+		}
+	}
+
+	if _, err := time.LoadLocation(job.Location); err != nil {
+		return fmt.Errorf("invalid location '%s': %w", job.Location, err)
+	}
+
+	return nil
+}
+
+// armJob starts a timer for the job's next run. The scheduler must be locked.
+func (c *Config) armJob(s *scheduler, job Job) {
+	if t, ok := s.timers[job.ID]; ok {
+		t.Stop()
+		delete(s.timers, job.ID)
+	}
+
+	next, ok := job.next(time.Now())
+	if !ok {
+		return
+	}
+
+	c.armJobIn(s, job, time.Until(next))
+}
+
+// armJobIn starts a timer running the job after the delay. The scheduler must
+// be locked.
+func (c *Config) armJobIn(s *scheduler, job Job, delay time.Duration) {
+	if t, ok := s.timers[job.ID]; ok {
+		t.Stop()
+	}
+
+	s.timers[job.ID] = time.AfterFunc(delay, func() {
+		c.runJob(s, job)
+	})
+}
+
+// removeJob stops and forgets a job. The scheduler must be locked.
+func (c *Config) removeJob(s *scheduler, id string) {
+	if t, ok := s.timers[id]; ok {
+		t.Stop()
+		delete(s.timers, id)
+	}
+
+	delete(s.jobs, id)
+	delete(s.retries, id)
+}
+
+// runJob delivers the job's message. Recurring jobs are re-armed right away,
+// while one-off jobs are only removed once delivered, retrying targets whose
+// platform was not connected yet with a growing delay until the retry limit.
+func (c *Config) runJob(s *scheduler, job Job) {
+	defer c.recoverPanic("scheduled job '" + job.ID + "'")
+
+	s.mu.Lock()
+	current, ok := s.jobs[job.ID]
+	if !ok || current.Cron != job.Cron || !current.At.Equal(job.At) {
+		// The job was removed or rescheduled since the timer was armed
+		s.mu.Unlock()
+		return
+	}
+	job = current
+
+	if job.Cron != "" {
+		c.armJob(s, job)
+	}
+	s.mu.Unlock()
+
+	unavailable := c.deliverJob(job)
+	if job.Cron != "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.jobs[job.ID]; !ok || current.Cron != "" || !current.At.Equal(job.At) {
+		return
+	}
+
+	switch retries := s.retries[job.ID]; {
+	case len(unavailable) == 0:
+		c.removeJob(s, job.ID)
+
+	case retries >= jobRetryLimit:
+		c.logger().Error("Giving up on scheduled job", "job", job.ID, "targets", len(unavailable))
+		c.removeJob(s, job.ID)
+
+	default:
+		job.Targets = unavailable
+		s.jobs[job.ID] = job
+		s.retries[job.ID] = retries + 1
+		c.armJobIn(s, job, min(jobRetryDelay<<retries, jobRetryDelayMax))
+	}
+
+	if err := c.saveJobs(s); err != nil {
+		c.logger().Error("Failed to persist scheduled jobs", "error", err)
+	}
+}
+
+// deliverJob sends the job's message to its targets, returning those whose
+// platform is not connected
+func (c *Config) deliverJob(job Job) (unavailable []Target) {
+	fields := make(map[string]string, len(job.Fields))
+	for k, v := range job.Fields {
+		fields[k] = v
+	}
+
+	msg := func() *Message {
+		defer c.recoverPanic("scheduled job '" + job.ID + "'")
+		return c.JobHandlers[job.Handler](fields)
+	}()
+	if msg == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, t := range job.Targets {
+		_, err := c.Send(ctx, t, msg)
+		switch {
+		case errors.Is(err, ErrPlatformUnavailable):
+			unavailable = append(unavailable, t)
+			c.logger().Warn("Delaying scheduled job until its platform connects", append(c.targetAttrs(t), "job", job.ID)...)
+
+		case err != nil:
+			c.logger().Error("Failed to deliver scheduled job", append(c.targetAttrs(t), "job", job.ID, "error", err)...)
+		}
+	}
+
+	return unavailable
+}
+
+// saveJobs persists all job definitions. The scheduler must be locked.
+func (c *Config) saveJobs(s *scheduler) error {
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal jobs: %w", err)
+	}
+
+	if err := c.WriteCache(jobsCacheKey, data); err != nil {
+		return fmt.Errorf("failed to persist jobs: %w", err)
+	}
+
+	return nil
+}
+
+// next returns the job's next run time after t. One-off jobs that are overdue
+// (i.e. missed while the bot was offline) run immediately.
+func (j Job) next(t time.Time) (time.Time, bool) {
+	if j.Cron == "" {
+		if j.At.Before(t) {
+			return t, true
+		}
+
+		return j.At, true
+	}
+
+	sched, err := parseCron(j.Cron)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	loc, err := time.LoadLocation(j.Location)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	next := sched.next(t.In(loc))
+	return next, !next.IsZero()
+}
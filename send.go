@@ -0,0 +1,339 @@
+package crossbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// ErrPlatformUnavailable is returned when sending to a platform that has not
+// been configured or has not finished connecting yet.
+var ErrPlatformUnavailable = errors.New("platform unavailable")
+
+// Target identifies a chat on a specific platform that messages can be sent to
+type Target struct {
+	Platform Platform
+
+	// Discord channel ID or Telegram chat ID (numeric or "@username")
+	ChatID string
+
+	// Optional Discord thread ID or Telegram message thread (topic) ID
+	ThreadID string
+}
+
+// MessageRef is a platform-neutral reference to a sent message, allowing it to
+// be edited or deleted later on.
+type MessageRef struct {
+	Target    Target
+	MessageID string
+}
+
+// Send posts a message to the target chat outside of a command
+func (c *Config) Send(ctx context.Context, target Target, msg *Message) (*MessageRef, error) {
+	return c.send(ctx, target, msg, "")
+}
+
+// SendAsync queues a message to the target chat like Send, without waiting
+// for its delivery. The result is delivered on the returned channel.
+func (c *Config) SendAsync(ctx context.Context, target Target, msg *Message) <-chan Delivery {
+	wait := c.queueSend(ctx, target, msg, "")
+
+	res := make(chan Delivery, 1)
+	go func() {
+		ref, err := wait()
+		res <- Delivery{Ref: ref, Err: err}
+	}()
+
+	return res
+}
+
+// send posts a message to the target chat, optionally as a reply to the
+// message with the ID replyTo in the same chat. Messages split to fit the
+// platform's limits are sent in order, returning a reference to the first.
+func (c *Config) send(ctx context.Context, target Target, msg *Message, replyTo string) (*MessageRef, error) {
+	return c.queueSend(ctx, target, msg, replyTo)()
+}
+
+// queueSend queues the message's parts to the target chat, returning a
+// function waiting for their delivery. Parts after a failed one are skipped.
+func (c *Config) queueSend(ctx context.Context, target Target, msg *Message, replyTo string) (wait func() (*MessageRef, error)) {
+	msg = c.loadAttachments(ctx, msg, target.Platform)
+	msg, choices := c.degrade(msg, target.Platform)
+	parts, err := c.fit(msg, target.Platform)
+	if err != nil {
+		return func() (*MessageRef, error) { return nil, err }
+	}
+
+	refs := make([]*MessageRef, len(parts))
+	jobs := make([]func(ctx context.Context) error, len(parts))
+	for i, part := range parts {
+		jobs[i] = func(ctx context.Context) error {
+			if i > 0 && refs[i-1] == nil {
+				return errPartSkipped
+			}
+
+			ref, err := c.sendPart(ctx, target, part, replyTo)
+			refs[i] = ref
+			return err
+		}
+	}
+
+	waitAll := c.enqueue(ctx, target, "send", jobs...)
+	return func() (*MessageRef, error) {
+		if err := waitAll(); err != nil {
+			return nil, err
+		}
+
+		if len(choices) > 0 {
+			c.setChoices(target, refs[len(refs)-1], choices)
+		}
+
+		return refs[0], nil
+	}
+}
+
+func (c *Config) sendPart(ctx context.Context, target Target, msg *Message, replyTo string) (*MessageRef, error) {
+	switch target.Platform {
+	case PlatformDiscord:
+		if c.discord.Load() == nil {
+			return nil, fmt.Errorf("failed to send Discord message: %w", ErrPlatformUnavailable)
+		}
+
+		resp := msg.Discord()
+		data := &discordgo.MessageSend{
+			Content:    resp.Content,
+			Embeds:     resp.Embeds,
+			Components: resp.Components,
+			Files:      resp.Files,
+		}
+
+		if replyTo != "" {
+			failIfNotExists := false
+			data.Reference = &discordgo.MessageReference{
+				MessageID:       replyTo,
+				ChannelID:       target.discordChannel(),
+				FailIfNotExists: &failIfNotExists,
+			}
+		}
+
+		m, err := c.discord.Load().ChannelMessageSendComplex(target.discordChannel(), data, discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send Discord message: %w", err)
+		}
+
+		return &MessageRef{Target: target, MessageID: m.ID}, nil
+
+	case PlatformTelegram:
+		if c.telegram.Load() == nil {
+			return nil, fmt.Errorf("failed to send Telegram message: %w", ErrPlatformUnavailable)
+		}
+
+		threadID, err := target.telegramThread()
+		if err != nil {
+			return nil, err
+		}
+
+		to := telegramReply{ChatID: target.telegramChat(), MessageThreadID: threadID}
+		if replyTo != "" {
+			id, err := strconv.Atoi(replyTo)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Telegram message ID '%s': %w", replyTo, err)
+			}
+
+			to.ReplyParameters = &models.ReplyParameters{
+				MessageID:                id,
+				AllowSendingWithoutReply: true,
+			}
+		}
+
+		m, err := sendTelegram(ctx, c.telegram.Load(), to, msg)
+		err = telegramError(err)
+		if err != nil {
+			// Retrying would repeat the part that was already sent
+			if m != nil {
+				err = permanent(err)
+			}
+
+			return nil, fmt.Errorf("failed to send Telegram message: %w", err)
+		}
+
+		return &MessageRef{Target: target, MessageID: strconv.Itoa(m.ID)}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported platform '%d'", target.Platform)
+	}
+}
+
+// Edit replaces the contents of a previously sent message
+func (c *Config) Edit(ctx context.Context, ref *MessageRef, msg *Message) error {
+	msg, choices := c.degrade(msg, ref.Target.Platform)
+	msg, err := c.fitOne(msg, ref.Target.Platform)
+	if err != nil {
+		return err
+	}
+
+	if len(choices) > 0 {
+		c.setChoices(ref.Target, ref, choices)
+	}
+
+	return c.enqueue(ctx, ref.Target, "edit", func(ctx context.Context) error {
+		return c.editMessage(ctx, ref, msg)
+	})()
+}
+
+func (c *Config) editMessage(ctx context.Context, ref *MessageRef, msg *Message) error {
+	switch ref.Target.Platform {
+	case PlatformDiscord:
+		if c.discord.Load() == nil {
+			return fmt.Errorf("failed to edit Discord message: %w", ErrPlatformUnavailable)
+		}
+
+		resp := msg.Discord()
+		_, err := c.discord.Load().ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         ref.MessageID,
+			Channel:    ref.Target.discordChannel(),
+			Content:    &resp.Content,
+			Embeds:     &resp.Embeds,
+			Components: &resp.Components,
+		}, discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to edit Discord message: %w", err)
+		}
+
+		return nil
+
+	case PlatformTelegram:
+		if c.telegram.Load() == nil {
+			return fmt.Errorf("failed to edit Telegram message: %w", ErrPlatformUnavailable)
+		}
+
+		id, err := strconv.Atoi(ref.MessageID)
+		if err != nil {
+			return fmt.Errorf("invalid Telegram message ID '%s': %w", ref.MessageID, err)
+		}
+
+		text, markup := msg.Telegram()
+		_, err = c.telegram.Load().EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      ref.Target.telegramChat(),
+			MessageID:   id,
+			Text:        text,
+			ParseMode:   models.ParseModeMarkdown,
+			ReplyMarkup: markup,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to edit Telegram message: %w", telegramError(err))
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported platform '%d'", ref.Target.Platform)
+	}
+}
+
+// Delete removes a previously sent message
+func (c *Config) Delete(ctx context.Context, ref *MessageRef) error {
+	return c.enqueue(ctx, ref.Target, "delete", func(ctx context.Context) error {
+		return c.deleteMessage(ctx, ref)
+	})()
+}
+
+func (c *Config) deleteMessage(ctx context.Context, ref *MessageRef) error {
+	switch ref.Target.Platform {
+	case PlatformDiscord:
+		if c.discord.Load() == nil {
+			return fmt.Errorf("failed to delete Discord message: %w", ErrPlatformUnavailable)
+		}
+
+		err := c.discord.Load().ChannelMessageDelete(ref.Target.discordChannel(), ref.MessageID, discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to delete Discord message: %w", err)
+		}
+
+		return nil
+
+	case PlatformTelegram:
+		if c.telegram.Load() == nil {
+			return fmt.Errorf("failed to delete Telegram message: %w", ErrPlatformUnavailable)
+		}
+
+		id, err := strconv.Atoi(ref.MessageID)
+		if err != nil {
+			return fmt.Errorf("invalid Telegram message ID '%s': %w", ref.MessageID, err)
+		}
+
+		_, err = c.telegram.Load().DeleteMessage(ctx, &bot.DeleteMessageParams{
+			ChatID:    ref.Target.telegramChat(),
+			MessageID: id,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete Telegram message: %w", telegramError(err))
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported platform '%d'", ref.Target.Platform)
+	}
+}
+
+// setFields exposes the target to handlers under the "platform", "chat" and
+// "thread" keys
+func (t Target) setFields(fields map[string]string) {
+	fields["platform"] = fmt.Sprint(t.Platform)
+	fields["chat"] = t.ChatID
+	if t.ThreadID != "" {
+		fields["thread"] = t.ThreadID
+	}
+}
+
+// FieldsTarget returns the chat a command was invoked in from its fields
+func FieldsTarget(fields map[string]string) (Target, error) {
+	platform, err := GetStringPlatform(fields["platform"])
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid platform: %w", err)
+	}
+
+	if fields["chat"] == "" {
+		return Target{}, errors.New("chat undefined")
+	}
+
+	return Target{Platform: platform, ChatID: fields["chat"], ThreadID: fields["thread"]}, nil
+}
+
+// discordChannel returns the channel to post in, as Discord threads are channels
+func (t Target) discordChannel() string {
+	if t.ThreadID != "" {
+		return t.ThreadID
+	}
+
+	return t.ChatID
+}
+
+// telegramChat returns the chat ID as an integer when possible, falling back
+// to the raw string for public "@username" chats
+func (t Target) telegramChat() any {
+	if id, err := strconv.ParseInt(t.ChatID, 10, 64); err == nil {
+		return id
+	}
+
+	return t.ChatID
+}
+
+func (t Target) telegramThread() (int, error) {
+	if t.ThreadID == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.Atoi(t.ThreadID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Telegram thread ID '%s': %w", t.ThreadID, err)
+	}
+
+	return id, nil
+}
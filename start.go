@@ -1,8 +1,8 @@
 package crossbot
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,9 +13,23 @@ func (c *Config) Start(cmds *[]*Command) error {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	for _, cmd := range *cmds {
+		if err := validateRateLimits(cmd.RateLimits); err != nil {
+			return fmt.Errorf("invalid command '%s': %w", cmd.name(), err)
+		}
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+
+	if c.MetricsAddress != "" {
+		go c.serveMetrics()
+	}
+
 	go func() {
 		if err := c.Telegram(cmds); err != nil {
-			log.Println("Telegram initialization error:", err)
+			c.logger().Error("Telegram initialization error", "error", err)
+			c.connectDone(PlatformTelegram)
 		}
 	}()
 
@@ -25,9 +39,76 @@ func (c *Config) Start(cmds *[]*Command) error {
 	}
 	defer cancelDg()
 
+	// Restore persisted jobs once platforms are connected
+	c.waitConnected(PlatformDiscord, PlatformTelegram)
+	if _, err := c.jobScheduler(); err != nil {
+		return fmt.Errorf("failed to start scheduler: %w", err)
+	}
+	c.restoreSessions()
+
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
 	<-sc
 
+	// Rate limits changed since the last batch would be lost otherwise
+	c.flushBuckets()
+
 	return nil
 }
+
+// baseContext returns the context requests derive from, which is cancelled
+// once the bot stops
+func (c *Config) baseContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// connecting returns the channel closed once the platform is done connecting
+func (c *Config) connecting(p Platform) chan struct{} {
+	c.connectedMu.Lock()
+	defer c.connectedMu.Unlock()
+
+	if c.connected == nil {
+		c.connected = make(map[Platform]chan struct{})
+	}
+
+	ch, ok := c.connected[p]
+	if !ok {
+		ch = make(chan struct{})
+		c.connected[p] = ch
+	}
+
+	return ch
+}
+
+// connectDone marks the platform as done connecting, whether it succeeded or
+// not
+func (c *Config) connectDone(p Platform) {
+	ch := c.connecting(p)
+
+	c.connectedMu.Lock()
+	defer c.connectedMu.Unlock()
+
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// waitConnected waits until each of the platforms that is configured is done
+// connecting
+func (c *Config) waitConnected(platforms ...Platform) {
+	for _, p := range platforms {
+		switch {
+		case p == PlatformDiscord && c.DiscordConfig == nil,
+			p == PlatformTelegram && c.TelegramConfig == nil:
+			continue
+		}
+
+		<-c.connecting(p)
+	}
+}
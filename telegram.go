@@ -3,91 +3,117 @@ package crossbot
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"strings"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (c *Config) Telegram(cmds *[]*Command) error {
-	var middlewares []bot.Middleware
+	// Panics are recovered before reaching the bot's update loop
+	middlewares := []bot.Middleware{c.middlewareRecover, c.middlewareTrace}
 	for _, cmd := range *cmds {
 		if cmd.Telegram.TextMiddleware != nil {
 			middlewares = append(middlewares, cmd.Telegram.TextMiddleware)
 		}
 	}
-	middlewares = append(middlewares, middlewareLogger)
+	middlewares = append(middlewares, c.middlewareLogger, c.telegramBridgeMiddleware, c.telegramConversationMiddleware)
+	c.registerConversations(cmds)
 
 	opts := []bot.Option{
 		bot.WithDefaultHandler(func(ctx context.Context, bot *bot.Bot, update *models.Update) {}),
 		bot.WithMiddlewares(middlewares...),
 		bot.WithCallbackQueryDataHandler("", bot.MatchTypePrefix, func(ctx context.Context, b *bot.Bot, update *models.Update) {
-			if update.Message != nil {
-				f := update.Message.From
-				log.Printf("%s %s callback: %s", f.FirstName, f.LastName, update.CallbackQuery.Data)
-			}
-
 			id := update.CallbackQuery.Data
-			cb, ok := CallbackCache[id]
+			cb, ok := LookupCallback(id)
+			c.recordCache("callback", ok)
 			if !ok {
 				return
 			}
+			c.metric().callbacks.WithLabelValues(platformName(PlatformTelegram), cb.origin.name()).Inc()
 
 			user := getUserFromUpdate(update)
 
-			var text string
-			var markup models.ReplyMarkup
-			if cb.Function != nil {
-				msg := cb.Run(user, PlatformTelegram)
-				text, markup = msg.Telegram()
+			target := Target{Platform: PlatformTelegram}
+			var (
+				ref       *MessageRef
+				inPrivate bool
+			)
+			if m := update.CallbackQuery.Message.Message; m != nil {
+				ref = &MessageRef{Target: telegramTarget(m), MessageID: fmt.Sprint(m.ID)}
+				target, inPrivate = ref.Target, m.Chat.Type == models.ChatTypePrivate
 			}
 
+			req := newRequest(ctx, target, user, telegramUserID(update))
+			if err := c.callbackAllowed(cb, req); err != nil {
+				b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+					CallbackQueryID: update.CallbackQuery.ID,
+					Text:            err.Error(),
+					ShowAlert:       true,
+				})
+				return
+			}
+
+			var msg *Message
+			if cb.Function != nil || cb.Action == CallbackActionAlert {
+				msg = c.runCallback(cb, req)
+			}
+
+			// Callbacks that failed or were turned away while busy only tell
+			// the user so
+			if msg != nil && msg.notice {
+				b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+					CallbackQueryID: update.CallbackQuery.ID,
+					Text:            msg.Title,
+					ShowAlert:       msg.Title != "",
+				})
+				return
+			}
+
+			// Results only the user may see are sent privately instead of
+			// changing the chat, unless the chat is already private. Alerts are
+			// only shown to the user anyway.
+			private := msg != nil && msg.visibility(cb.origin.visibility()) != VisibilityPublic && !inPrivate
+			if private && ref != nil && cb.Action != CallbackActionDeleteMessage && cb.Action != CallbackActionAlert {
+				c.reply(ctx, ref.Target, req.UserID, ref.MessageID, msg, cb.origin.visibility())
+				b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: update.CallbackQuery.ID})
+				return
+			}
+
+			var err error
 			switch cb.Action {
 			case CallbackActionEditMessage:
-				b.EditMessageText(ctx, &bot.EditMessageTextParams{
-					ChatID:          update.CallbackQuery.Message.Message.Chat.ID,
-					MessageID:       update.CallbackQuery.Message.Message.ID,
-					InlineMessageID: update.CallbackQuery.InlineMessageID,
-					Text:            text,
-					ReplyMarkup:     markup,
-				})
+				if msg != nil && ref != nil {
+					err = c.Edit(ctx, ref, msg)
+				}
 
 			case CallbackActionCreateMessage:
-				b.SendMessage(ctx, &bot.SendMessageParams{
-					ChatID: update.CallbackQuery.Message.Message.Chat.ID,
-					Text:   text,
-					ReplyParameters: &models.ReplyParameters{
-						MessageID:                update.Message.ID,
-						ChatID:                   update.Message.Chat.ID,
-						AllowSendingWithoutReply: true,
-					},
-					ReplyMarkup: markup,
-				})
+				if msg != nil && ref != nil {
+					_, err = c.send(ctx, ref.Target, msg, ref.MessageID)
+				}
 
 			case CallbackActionDeleteMessage:
-				b.DeleteMessage(ctx, &bot.DeleteMessageParams{
-					ChatID:    update.CallbackQuery.Message.Message.Chat.ID,
-					MessageID: update.CallbackQuery.Message.Message.ID,
-				})
+				if ref != nil {
+					err = c.Delete(ctx, ref)
+				}
 
 			case CallbackActionAlert:
-				fields, err := cb.ParseFields(user, PlatformTelegram)
-				if err != nil {
-					return
+				params := &bot.AnswerCallbackQueryParams{CallbackQueryID: update.CallbackQuery.ID}
+				if msg != nil {
+					params.Text, params.ShowAlert = msg.Title, true
 				}
 
-				b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-					CallbackQueryID: update.CallbackQuery.ID,
-					Text:            cb.AlertMessage(fields),
-					ShowAlert:       true,
-				})
-
+				b.AnswerCallbackQuery(ctx, params)
 				return
 			}
 
+			if err != nil {
+				c.logger().Error("Failed to handle Telegram callback", "error", err)
+			}
+
 			b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 				CallbackQueryID: update.CallbackQuery.ID,
 				ShowAlert:       false,
@@ -99,6 +125,7 @@ func (c *Config) Telegram(cmds *[]*Command) error {
 	if err != nil {
 		return fmt.Errorf("failed to create new bot instance: %w", err)
 	}
+	c.telegram.Store(b)
 
 	if err := c.RegisterTelegram(b, cmds); err != nil {
 		return fmt.Errorf("failed to register Telegram commands: %w", err)
@@ -113,48 +140,197 @@ func (c *Config) Telegram(cmds *[]*Command) error {
 			cmdCpy, nameCpy := cmd, name
 
 			fn := func(ctx context.Context, b *bot.Bot, update *models.Update) {
-				txt := update.Message.Text
+				txt := telegramText(update.Message)
 				txt = strings.TrimLeft(txt, fmt.Sprintf("@%s", c.TelegramConfig.BotUsername))
 				txt = strings.TrimSpace(txt)
 
-				user := getUserFromUpdate(update)
-				text, markup := c.Run(cmdCpy, user, txt, nameCpy, PlatformTelegram)
-				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-					ChatID: update.Message.Chat.ID,
-					Text:   text,
-					// ParseMode: "MarkdownV2",
-					ReplyParameters: &models.ReplyParameters{
-						MessageID:                update.Message.ID,
-						ChatID:                   update.Message.Chat.ID,
-						AllowSendingWithoutReply: true,
-					},
-					ReplyMarkup: markup,
-				})
-				if err != nil {
-					log.Println("Failed to send Telegram message:", err)
+				req := newRequest(ctx, telegramTarget(update.Message), getUserFromUpdate(update), telegramUserID(update))
+
+				// Files are taken from the command message, or the message it replies to
+				files := telegramFiles(b, update.Message)
+				if len(files) == 0 && update.Message.ReplyToMessage != nil {
+					files = telegramFiles(b, update.Message.ReplyToMessage)
 				}
+
+				for i, name := range cmdCpy.Text.Attachments {
+					if i < len(files) {
+						req.Files[name] = files[i]
+					}
+				}
+
+				p := &chatResponder{
+					c:          c,
+					chat:       req.Target,
+					replyTo:    fmt.Sprint(update.Message.ID),
+					userID:     req.UserID,
+					visibility: cmdCpy.Visibility,
+				}
+				req.progress = p.progress
+
+				stop := c.telegramTyping(ctx, b, req.Target)
+				msg := c.run(cmdCpy, req, txt, nameCpy)
+				stop()
+
+				p.finish(ctx, msg)
 			}
 
-			b.RegisterHandler(bot.HandlerTypeMessageText, "/"+nameCpy, bot.MatchTypePrefix, fn)
+			// Commands may also be sent as the caption of a photo or document
+			match := func(update *models.Update) bool {
+				return update.Message != nil && strings.HasPrefix(telegramText(update.Message), "/"+nameCpy)
+			}
+
+			b.RegisterHandlerMatchFunc(match, fn)
 		}
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	c.connectDone(PlatformTelegram)
+
+	ctx, cancel := signal.NotifyContext(c.baseContext(), os.Interrupt)
 	defer cancel()
 	b.Start(ctx)
 	return nil
 }
 
-func middlewareLogger(next bot.HandlerFunc) bot.HandlerFunc {
+func (c *Config) middlewareRecover(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		defer c.recoverPanic("Telegram update handler")
+		next(ctx, b, update)
+	}
+}
+
+// middlewareTrace runs the handling of each update in a span
+func (c *Config) middlewareTrace(next bot.HandlerFunc) bot.HandlerFunc {
 	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
-		if update.Message != nil {
-			f := update.Message.From
-			log.Printf("%s %s (%s): %s", f.FirstName, f.LastName, f.Username, update.Message.Text)
+		target := Target{Platform: PlatformTelegram}
+		kind := "other"
+		switch {
+		case update.Message != nil:
+			target, kind = telegramTarget(update.Message), "message"
+		case update.CallbackQuery != nil:
+			kind = "callback"
+			if m := update.CallbackQuery.Message.Message; m != nil {
+				target = telegramTarget(m)
+			}
 		}
+
+		ctx, span := c.startSpan(ctx, "update", target,
+			attribute.String("crossbot.update", kind),
+			attribute.Int64("telegram.update_id", update.ID),
+		)
+		defer span.End()
+
 		next(ctx, b, update)
 	}
 }
 
+func (c *Config) middlewareLogger(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if m := update.Message; m != nil && m.From != nil {
+			c.logMessage(telegramTarget(m), getUserFromUpdate(update), telegramUserID(update), telegramText(m))
+		}
+		next(ctx, b, update)
+	}
+}
+
+// telegramTarget returns the chat and topic a message was sent in
+func telegramTarget(m *models.Message) Target {
+	t := Target{Platform: PlatformTelegram, ChatID: fmt.Sprint(m.Chat.ID)}
+	if m.MessageThreadID != 0 {
+		t.ThreadID = fmt.Sprint(m.MessageThreadID)
+	}
+
+	return t
+}
+
+// telegramConversationMiddleware picks numbered choices and answers ongoing
+// conversations with the user's message instead of passing it on to the
+// command handlers
+func (c *Config) telegramConversationMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		m := update.Message
+		if m == nil || m.From == nil || (strings.HasPrefix(m.Text, "/") && !strings.HasPrefix(m.Text, "/cancel")) {
+			next(ctx, b, update)
+			return
+		}
+
+		target := telegramTarget(m)
+		if c.choose(ctx, target, getUserFromUpdate(update), telegramUserID(update), m.Text, fmt.Sprint(m.ID)) {
+			return
+		}
+
+		msg, ok := c.answer(target, getUserFromUpdate(update), telegramUserID(update), m.Text)
+		if !ok {
+			next(ctx, b, update)
+			return
+		}
+
+		c.reply(ctx, target, telegramUserID(update), fmt.Sprint(m.ID), msg, VisibilityPublic)
+	}
+}
+
+// telegramText returns the message's text, or its caption for media messages
+func telegramText(m *models.Message) string {
+	if m.Text != "" {
+		return m.Text
+	}
+
+	return m.Caption
+}
+
+// telegramFiles returns the files attached to a message
+func telegramFiles(b *bot.Bot, m *models.Message) (files []*File) {
+	add := func(id, name, contentType string, size int64) {
+		files = append(files, &File{
+			Name:        name,
+			ContentType: contentType,
+			Size:        size,
+			url: func(ctx context.Context) (string, error) {
+				f, err := b.GetFile(ctx, &bot.GetFileParams{FileID: id})
+				if err != nil {
+					return "", err
+				}
+
+				return b.FileDownloadLink(f), nil
+			},
+		})
+	}
+
+	// Photos are provided in several sizes, the last one being the largest
+	if len(m.Photo) > 0 {
+		p := m.Photo[len(m.Photo)-1]
+		add(p.FileID, "photo.jpg", "image/jpeg", int64(p.FileSize))
+	}
+
+	if d := m.Document; d != nil {
+		add(d.FileID, d.FileName, d.MimeType, d.FileSize)
+	}
+
+	if v := m.Video; v != nil {
+		add(v.FileID, v.FileName, v.MimeType, v.FileSize)
+	}
+
+	if a := m.Audio; a != nil {
+		add(a.FileID, a.FileName, a.MimeType, a.FileSize)
+	}
+
+	if v := m.Voice; v != nil {
+		add(v.FileID, "voice.ogg", v.MimeType, v.FileSize)
+	}
+
+	return files
+}
+
+func telegramUserID(update *models.Update) string {
+	switch {
+	case update.Message != nil && update.Message.From != nil:
+		return fmt.Sprint(update.Message.From.ID)
+	case update.CallbackQuery != nil:
+		return fmt.Sprint(update.CallbackQuery.From.ID)
+	default:
+		return ""
+	}
+}
+
 func getUserFromUpdate(update *models.Update) string {
 	var from *models.User
 	if update.Message != nil {
@@ -0,0 +1,77 @@
+package crossbot
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by the package
+const tracerName = "gitlab.com/AlexJarrah/crossbot"
+
+// tracer returns the tracer of the config's provider, falling back to the
+// global one
+func (c *Config) tracer() trace.Tracer {
+	tp := c.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName)
+}
+
+// startSpan starts a span for work in the target chat
+func (c *Config) startSpan(ctx context.Context, name string, target Target, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("crossbot.platform", platformName(target.Platform)),
+		attribute.String("crossbot.chat", c.chatID(target)),
+	)
+	if target.ThreadID != "" {
+		attrs = append(attrs, attribute.String("crossbot.thread", target.ThreadID))
+	}
+
+	return c.tracer().Start(ctx, "crossbot."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// traced runs the handler in a span, with the span in the request's context.
+// Handlers that panic or outlive their context fail the span.
+func (c *Config) traced(name string, h Handler) Handler {
+	return func(req *Request) *Message {
+		ctx, span := c.startSpan(req.Context(), name, req.Target,
+			attribute.String("crossbot.command", req.Command),
+			attribute.Bool("crossbot.callback", req.Callback),
+		)
+		defer span.End()
+
+		prev := req.ctx
+		req.ctx = ctx
+		defer func() { req.ctx = prev }()
+
+		// Runs before the context is restored and the panic reaches guard
+		defer func() {
+			if p := recover(); p != nil {
+				span.SetStatus(codes.Error, outcomePanic)
+				panic(p)
+			}
+
+			if ctx.Err() != nil {
+				span.SetStatus(codes.Error, contextOutcome(ctx))
+			}
+		}()
+
+		return h(req)
+	}
+}
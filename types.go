@@ -1,6 +1,8 @@
 package crossbot
 
 import (
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -18,6 +20,39 @@ type (
 
 		// Function to be ran once command is called.
 		Handler func(fields map[string]string) *Message
+
+		// Optional function ran instead of Handler, receiving the whole request
+		// including the user's attached files
+		RequestHandler Handler
+
+		// Optional follow-up questions asked instead of running Handler
+		Conversation *Conversation
+
+		// Who can see the command's responses. Defaults to everyone in the chat.
+		Visibility Visibility
+
+		// Middlewares ran around the handler on every platform, including for
+		// callbacks of the command's responses
+		Middlewares []Middleware
+
+		// Optional rules restricting who may run the command and where
+		Access *Access
+
+		// Rate limits applied to the command and callbacks of its responses.
+		// Page turns and conversation answers count as part of the invocation.
+		RateLimits []RateLimit
+
+		// How long the handler and callbacks of its responses may run,
+		// overriding Config.HandlerTimeout
+		Timeout time.Duration
+
+		// Maximum number of instances of the handler and callbacks of its
+		// responses running at once. Defaults to unlimited.
+		Concurrency int
+
+		// Maximum number of requests waiting to run the command, overriding
+		// Config.QueueSize
+		QueueSize int
 	}
 
 	// TextCommand is a command's text configuration
@@ -34,6 +69,12 @@ type (
 		// the user will have a key and value in the map provided to the handler.
 		Options []string
 
+		// Files the command requires, in order. On Discord these are matched with
+		// the command's attachment options by name, while on Telegram they are
+		// taken from the command message or the message it replies to. Attached
+		// files are available to RequestHandler through Request.File.
+		Attachments []string
+
 		// String to search for in the user message to seperate fields on. By default,
 		// this is set to " ", meaning any space in the user's message will mark a new
 		// field. This can be disabled by setting it to "" to allow the handler to get
@@ -0,0 +1,92 @@
+package crossbot
+
+import (
+	"context"
+	"fmt"
+)
+
+// Visibility decides who can see a command's response
+type Visibility uint8
+
+const (
+	// Everyone in the chat sees the response
+	VisibilityPublic Visibility = iota
+
+	// Only the invoking user sees the response. Discord shows it as an
+	// ephemeral message, while Telegram, lacking those, sends it privately.
+	VisibilityEphemeral
+
+	// The response is sent as a private message to the invoking user
+	VisibilityPrivate
+)
+
+var (
+	// privateAck replaces responses sent privately in the invoking chat
+	privateAck = &Message{Title: "📬 Sent you a private message"}
+
+	// privateFailed is shown when the user cannot be messaged privately
+	privateFailed = &Message{
+		Title:       "I couldn't send you a private message",
+		Description: "Make sure I'm allowed to message you privately, then try again",
+	}
+)
+
+// visibility returns the response's visibility, falling back to the command's
+// if the message does not restrict it
+func (m *Message) visibility(cmd Visibility) Visibility {
+	if m.Visibility != VisibilityPublic {
+		return m.Visibility
+	}
+
+	return cmd
+}
+
+// visibility returns the command's visibility, public for unknown commands
+func (cmd *Command) visibility() Visibility {
+	if cmd == nil {
+		return VisibilityPublic
+	}
+
+	return cmd.Visibility
+}
+
+// reply responds to a message in the chat with the result, sent privately
+// with an acknowledgement in the chat unless its visibility is public
+func (c *Config) reply(ctx context.Context, chat Target, userID, replyTo string, msg *Message, vis Visibility) {
+	r := &chatResponder{c: c, chat: chat, replyTo: replyTo, userID: userID, visibility: vis}
+	r.finish(ctx, msg)
+}
+
+// privateTarget returns the private chat with the user
+func (c *Config) privateTarget(p Platform, userID string) (Target, error) {
+	switch p {
+	case PlatformDiscord:
+		if c.discord.Load() == nil {
+			return Target{}, fmt.Errorf("failed to open Discord DM: %w", ErrPlatformUnavailable)
+		}
+
+		ch, err := c.discord.Load().UserChannelCreate(userID)
+		if err != nil {
+			return Target{}, fmt.Errorf("failed to open Discord DM: %w", err)
+		}
+
+		return Target{Platform: PlatformDiscord, ChatID: ch.ID}, nil
+
+	case PlatformTelegram:
+		// Private chats share the user's ID
+		return Target{Platform: PlatformTelegram, ChatID: userID}, nil
+
+	default:
+		return Target{}, fmt.Errorf("unsupported platform '%d'", p)
+	}
+}
+
+// sendPrivate sends the message to the user's private chat
+func (c *Config) sendPrivate(ctx context.Context, p Platform, userID string, msg *Message) (*MessageRef, error) {
+	target, err := c.privateTarget(p, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, target, msg, "")
+}
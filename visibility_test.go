@@ -0,0 +1,77 @@
+package crossbot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMessageVisibility(t *testing.T) {
+	tests := []struct {
+		msg  Visibility
+		cmd  Visibility
+		want Visibility
+	}{
+		{VisibilityPublic, VisibilityPublic, VisibilityPublic},
+		{VisibilityPublic, VisibilityEphemeral, VisibilityEphemeral},
+		{VisibilityPublic, VisibilityPrivate, VisibilityPrivate},
+		{VisibilityEphemeral, VisibilityPublic, VisibilityEphemeral},
+		{VisibilityPrivate, VisibilityEphemeral, VisibilityPrivate},
+		{VisibilityEphemeral, VisibilityPrivate, VisibilityEphemeral},
+	}
+
+	for _, tt := range tests {
+		m := &Message{Visibility: tt.msg}
+		if got := m.visibility(tt.cmd); got != tt.want {
+			t.Errorf("message %d with command %d has visibility %d, want %d", tt.msg, tt.cmd, got, tt.want)
+		}
+	}
+
+	var cmd *Command
+	if got := cmd.visibility(); got != VisibilityPublic {
+		t.Errorf("unknown command has visibility %d, want public", got)
+	}
+}
+
+func TestDiscordPrivate(t *testing.T) {
+	ephemeral := &discordgo.Message{Flags: discordgo.MessageFlagsEphemeral}
+
+	tests := []struct {
+		name string
+		i    *discordgo.Interaction
+		vis  Visibility
+		want bool
+	}{
+		{"dm public", &discordgo.Interaction{}, VisibilityPublic, true},
+		{"dm private", &discordgo.Interaction{}, VisibilityPrivate, true},
+		{"guild public", &discordgo.Interaction{GuildID: "g", Message: &discordgo.Message{}}, VisibilityPublic, true},
+		{"guild ephemeral", &discordgo.Interaction{GuildID: "g", Message: &discordgo.Message{}}, VisibilityEphemeral, false},
+		{"guild private", &discordgo.Interaction{GuildID: "g"}, VisibilityPrivate, false},
+		{"ephemeral public", &discordgo.Interaction{GuildID: "g", Message: ephemeral}, VisibilityPublic, true},
+		{"ephemeral ephemeral", &discordgo.Interaction{GuildID: "g", Message: ephemeral}, VisibilityEphemeral, true},
+		{"ephemeral private", &discordgo.Interaction{GuildID: "g", Message: ephemeral}, VisibilityPrivate, false},
+	}
+
+	for _, tt := range tests {
+		if got := discordPrivate(tt.i, tt.vis); got != tt.want {
+			t.Errorf("%s: discordPrivate = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrivateTarget(t *testing.T) {
+	c := &Config{}
+
+	got, err := c.privateTarget(PlatformTelegram, "42")
+	if err != nil || got != (Target{Platform: PlatformTelegram, ChatID: "42"}) {
+		t.Errorf("Telegram private target = %v, %v", got, err)
+	}
+
+	if _, err := c.privateTarget(PlatformDiscord, "42"); err == nil {
+		t.Error("Discord private target found without a session")
+	}
+
+	if _, err := c.privateTarget(PlatformGuilded, "42"); err == nil {
+		t.Error("Guilded private target found")
+	}
+}